@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+)
+
+// parseSeverity converts a severity name as used on the command line.
+func parseSeverity(name string) (problemSeverity, error) {
+	for _, i := range []problemSeverity{severityWarning, severityError} {
+		if i.String() == name {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown severity %q", name)
+}
+
+// writeProblems writes one line per problem, prefixed with the input name.
+// The number of problems at or above the given severity is returned.
+func writeProblems(w io.Writer, input string, problems []problem, minSeverity problemSeverity) int {
+	var count int
+
+	for _, p := range problems {
+		fmt.Fprintf(w, "%s: %s\n", input, p)
+
+		if p.severity >= minSeverity {
+			count++
+		}
+	}
+
+	return count
+}
+
+type checkCommand struct {
+	merged bool
+	failOn string
+}
+
+var _ subcommand = (*checkCommand)(nil)
+
+func (c *checkCommand) register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.merged, "merged", false, "Also check the result of merging all inputs")
+	fs.StringVar(&c.failOn, "fail-on", severityError.String(), `Minimum severity causing a failure ("warning" or "error")`)
+}
+
+func (c *checkCommand) run(ctx context.Context, fs *flag.FlagSet) error {
+	minSeverity, err := parseSeverity(c.failOn)
+	if err != nil {
+		return &usageError{err}
+	}
+
+	inputPaths := fs.Args()
+
+	if len(inputPaths) == 0 {
+		inputPaths = []string{stdinPlaceholder}
+	}
+
+	var count int
+
+	merger := newMetricsMerger(mergeOptions{})
+
+	for _, w := range inputWrappersFromArgs(inputPaths, inputOptions{}) {
+		p, err := readMetricFamilies(ctx, w, nil)
+		if err != nil {
+			return err
+		}
+
+		// Check before merging modifies the families
+		count += writeProblems(stdoutWriter, p.name, lintFamilies(sortedFamilies(p.families)), minSeverity)
+
+		if c.merged {
+			if err := merger.append(p); err != nil {
+				return err
+			}
+		}
+	}
+
+	if c.merged {
+		count += writeProblems(stdoutWriter, "merged", lintFamilies(merger.finalize().families), minSeverity)
+	}
+
+	if count > 0 {
+		return &validationError{problems: count}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCheckCommand(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	first := filepath.Join(tmpdir, "first.prom")
+	second := filepath.Join(tmpdir, "second.prom")
+
+	for path, content := range map[string]string{
+		first:  "# HELP up Up.\n# TYPE up gauge\nup{job=\"a\"} 1\n# TYPE requests counter\nrequests 1\n",
+		second: "# HELP up Up.\n# TYPE up gauge\nup{job=\"a\"} 0\n",
+	} {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		want    string
+		wantErr *regexp.Regexp
+	}{
+		{
+			name: "valid",
+			args: []string{second},
+		},
+		{
+			name: "warnings",
+			args: []string{first, second},
+			want: first + `: warning: requests: no help text
+` + first + `: warning: requests: counter metrics should have "_total" suffix
+`,
+		},
+		{
+			name: "fail on warning",
+			args: []string{"--fail-on", "warning", first},
+			want: first + `: warning: requests: no help text
+` + first + `: warning: requests: counter metrics should have "_total" suffix
+`,
+			wantErr: regexp.MustCompile(`^validation found 2 problem\(s\)$`),
+		},
+		{
+			name: "merged",
+			args: []string{"--merged", second, second},
+			want: `merged: error: up: duplicate series up{job="a"}
+`,
+			wantErr: regexp.MustCompile(`^validation found 1 problem\(s\)$`),
+		},
+		{
+			name:    "bad severity",
+			args:    []string{"--fail-on", "fatal"},
+			wantErr: regexp.MustCompile(`^unknown severity "fatal"$`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			stdout := withReplacedStdoutWriter(t)
+
+			var cmd checkCommand
+
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+
+			cmd.register(fs)
+
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+
+			err := cmd.run(context.Background(), fs)
+
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Errorf("run() failed: %v", err)
+				}
+			} else if err == nil || !tc.wantErr.MatchString(err.Error()) {
+				t.Errorf("run() error %q doesn't match %q", err, tc.wantErr.String())
+			}
+
+			if diff := cmp.Diff(stdout.String(), tc.want); diff != "" {
+				t.Errorf("Output difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
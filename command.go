@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+)
+
+// subcommand implements an operation other than merging.
+type subcommand interface {
+	// register adds the command flags to the flag set.
+	register(fs *flag.FlagSet)
+
+	// run executes the command with the parsed flags and arguments.
+	run(ctx context.Context, fs *flag.FlagSet) error
+}
+
+type subcommandInfo struct {
+	name        string
+	args        string
+	description string
+	new         func() subcommand
+}
+
+var subcommands = []subcommandInfo{
+	{
+		name:        "check",
+		args:        "[file...]",
+		description: "Check inputs for naming convention violations and inconsistent metrics.",
+		new:         func() subcommand { return &checkCommand{} },
+	},
+	{
+		name:        "diff",
+		args:        "<old> <new>",
+		description: "Show metric families and series added, removed or changed between two inputs.",
+		new:         func() subcommand { return &diffCommand{} },
+	},
+	{
+		name:        "split",
+		args:        "--output-dir <dir> [file...]",
+		description: "Write the metrics of the inputs into one file per family, label value or group.",
+		new:         func() subcommand { return &splitCommand{} },
+	},
+	{
+		name:        "stats",
+		args:        "[file...]",
+		description: "Summarize series and sample counts per input and family and the most diverse labels.",
+		new:         func() subcommand { return &statsCommand{} },
+	},
+}
+
+func findSubcommand(name string) *subcommandInfo {
+	for idx := range subcommands {
+		if subcommands[idx].name == name {
+			return &subcommands[idx]
+		}
+	}
+
+	return nil
+}
+
+// lookupSubcommand returns the command named by the first argument, if any.
+// Earlier versions treated all arguments as inputs, so a file with the same
+// name as a command takes precedence.
+func lookupSubcommand(args []string) *subcommandInfo {
+	if len(args) == 0 {
+		return nil
+	}
+
+	info := findSubcommand(args[0])
+
+	if info != nil {
+		if _, err := os.Lstat(args[0]); err == nil {
+			log.Printf("Reading %q as an input file instead of running the %s command; run the command from another directory", args[0], info.name)
+			return nil
+		}
+	}
+
+	return info
+}
+
+// subcommandsHelp describes the available commands for the usage text.
+func subcommandsHelp() string {
+	var result string
+
+	for _, i := range subcommands {
+		result += fmt.Sprintf("  %-6s  %s\n", i.name, i.description)
+	}
+
+	return result
+}
+
+// runSubcommand parses the arguments and executes the command. The returned
+// error is nil on success.
+func runSubcommand(ctx context.Context, info *subcommandInfo, args []string) error {
+	fs := flag.NewFlagSet(info.name, flag.ExitOnError)
+	fs.Usage = func() {
+		w := fs.Output()
+
+		fmt.Fprintf(w, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n", os.Args[0], info.name, info.args, info.description)
+		fs.PrintDefaults()
+	}
+
+	cmd := info.new()
+	cmd.register(fs)
+
+	fs.Parse(args)
+
+	return cmd.run(ctx, fs)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLookupSubcommand(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	if err := os.WriteFile("stats", []byte("up 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		args []string
+		want string
+	}{
+		{},
+		{args: []string{"a.prom"}},
+		{args: []string{"check", "a.prom"}, want: "check"},
+		{args: []string{"--output", "check"}},
+
+		// Existing files are merged as before commands were added
+		{args: []string{"stats", "a.prom"}},
+	} {
+		var got string
+
+		if info := lookupSubcommand(tc.args); info != nil {
+			got = info.name
+		}
+
+		if got != tc.want {
+			t.Errorf("lookupSubcommand(%q) returned %q, want %q", tc.args, got, tc.want)
+		}
+	}
+}
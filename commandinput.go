@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// commandInputPrefix marks inputs given as a command line, e.g.
+// "exec:/usr/local/bin/collect-raid --flag".
+const commandInputPrefix = "exec:"
+
+const defaultCommandInputTimeout = 10 * time.Second
+
+// commandInputArgs returns the program and its arguments if the input is a
+// command.
+func commandInputArgs(input string) ([]string, bool) {
+	if rest, ok := strings.CutPrefix(input, commandInputPrefix); ok {
+		return strings.Fields(rest), true
+	}
+
+	return nil, false
+}
+
+// commandInputWrapper runs a program and reads metrics in the text format from
+// its standard output. Standard error is logged.
+type commandInputWrapper struct {
+	args    []string
+	timeout time.Duration
+}
+
+var _ inputWrapper = (*commandInputWrapper)(nil)
+
+func (w *commandInputWrapper) Name() string {
+	return commandInputPrefix + strings.Join(w.args, " ")
+}
+
+func (w *commandInputWrapper) Process(ctx context.Context, fn func(io.Reader) error) error {
+	name := w.Name()
+
+	if len(w.args) == 0 {
+		return fmt.Errorf("%s: missing command", name)
+	}
+
+	timeout := w.timeout
+
+	if timeout <= 0 {
+		timeout = defaultCommandInputTimeout
+	}
+
+	cmdCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stderr bytes.Buffer
+
+	cmd := exec.CommandContext(cmdCtx, w.args[0], w.args[1:]...)
+	cmd.Stderr = &stderr
+
+	// Don't wait indefinitely for children keeping the output open
+	cmd.WaitDelay = time.Second
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	processErr := fn(stdout)
+
+	if processErr != nil {
+		// Stop the command instead of waiting for it to write all output
+		cancel()
+	}
+
+	waitErr := cmd.Wait()
+
+	for _, line := range strings.Split(strings.TrimRight(stderr.String(), "\n"), "\n") {
+		if line != "" {
+			log.Printf("%s: %s", name, line)
+		}
+	}
+
+	switch {
+	case ctx.Err() != nil:
+		return fmt.Errorf("%s: %w", name, ctx.Err())
+
+	case processErr != nil:
+		return fmt.Errorf("%s: %w", name, processErr)
+
+	case waitErr != nil && errors.Is(cmdCtx.Err(), context.DeadlineExceeded):
+		return fmt.Errorf("%s: timeout after %v: %w", name, timeout, cmdCtx.Err())
+
+	case waitErr != nil:
+		return fmt.Errorf("%s: %w", name, waitErr)
+	}
+
+	return nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestCommandInputArgs(t *testing.T) {
+	for _, tc := range []struct {
+		input  string
+		want   []string
+		wantOk bool
+	}{
+		{input: "file.prom"},
+		{input: "./exec:file.prom"},
+		{input: "exec:", wantOk: true},
+		{input: "exec:/bin/true", want: []string{"/bin/true"}, wantOk: true},
+		{input: "exec: collect  --flag value ", want: []string{"collect", "--flag", "value"}, wantOk: true},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			got, ok := commandInputArgs(tc.input)
+
+			if ok != tc.wantOk {
+				t.Errorf("commandInputArgs(%q) returned %v, want %v", tc.input, ok, tc.wantOk)
+			}
+
+			if diff := cmp.Diff(got, tc.want, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("commandInputArgs() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCommandInputWrapper(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test commands require a POSIX shell")
+	}
+
+	for _, tc := range []struct {
+		name       string
+		script     string
+		processErr error
+		cancel     bool
+		want       string
+		wantLog    *regexp.Regexp
+		wantErr    *regexp.Regexp
+	}{
+		{
+			name:   "success",
+			script: `echo "up 1"`,
+			want:   "up 1\n",
+		},
+		{
+			name:    "stderr",
+			script:  `echo "up 1"; echo warning >&2`,
+			want:    "up 1\n",
+			wantLog: regexp.MustCompile(`(?m): warning$`),
+		},
+		{
+			name:    "exit status",
+			script:  `echo "up 1"; exit 3`,
+			want:    "up 1\n",
+			wantErr: regexp.MustCompile(`: exit status 3$`),
+		},
+		{
+			name:    "timeout",
+			script:  `echo "up 1"; exec sleep 10`,
+			want:    "up 1\n",
+			wantErr: regexp.MustCompile(`: timeout after 100ms: context deadline exceeded$`),
+		},
+		{
+			name:    "cancelled",
+			script:  `exec sleep 10`,
+			cancel:  true,
+			wantErr: regexp.MustCompile(`: context canceled$`),
+		},
+		{
+			name:       "process error",
+			script:     `while :; do echo "up 1"; done`,
+			processErr: io.ErrUnexpectedEOF,
+			wantErr:    regexp.MustCompile(`: unexpected EOF$`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var logBuf bytes.Buffer
+
+			origLogWriter := log.Writer()
+			t.Cleanup(func() {
+				log.SetOutput(origLogWriter)
+			})
+
+			log.SetOutput(&logBuf)
+
+			w := &commandInputWrapper{
+				args:    []string{"sh", "-c", tc.script},
+				timeout: 100 * time.Millisecond,
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			if tc.cancel {
+				time.AfterFunc(10*time.Millisecond, cancel)
+				w.timeout = time.Minute
+			}
+
+			var got string
+
+			start := time.Now()
+
+			err := w.Process(ctx, func(r io.Reader) error {
+				if tc.processErr != nil {
+					return tc.processErr
+				}
+
+				content, err := io.ReadAll(r)
+				got = string(content)
+				return err
+			})
+
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Process() took %v", elapsed)
+			}
+
+			if tc.wantErr != nil {
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Errorf("Process() failed with %v, want match for %q", err, tc.wantErr.String())
+				}
+			} else if err != nil {
+				t.Errorf("Process() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("Process() content difference (-got +want):\n%s", diff)
+			}
+
+			if tc.wantLog != nil && !tc.wantLog.MatchString(logBuf.String()) {
+				t.Errorf("Log %q doesn't match %q", logBuf.String(), tc.wantLog.String())
+			}
+
+			if !strings.HasPrefix(w.Name(), "exec:sh -c ") {
+				t.Errorf("Name() returned %q", w.Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// fileConfig is the structure of the file given via --config.
+type fileConfig struct {
+	Jobs []jobConfig `yaml:"jobs"`
+}
+
+type jobConfig struct {
+	Name   string        `yaml:"name"`
+	Inputs []inputConfig `yaml:"inputs"`
+
+	// Regular expressions matched against family names.
+	Include []string `yaml:"include"`
+	Exclude []string `yaml:"exclude"`
+
+	Relabel      []relabelConfig `yaml:"relabel"`
+	OnConflict   string          `yaml:"on_conflict"`
+	KeepGoing    bool            `yaml:"keep_going"`
+	InputMetrics bool            `yaml:"input_metrics"`
+	SelfMetrics  bool            `yaml:"self_metrics"`
+	Validate     bool            `yaml:"validate"`
+	ShowInputs   bool            `yaml:"show_inputs"`
+	Limits       limitsConfig    `yaml:"limits"`
+	Timeout      model.Duration  `yaml:"timeout"`
+	Lock         bool            `yaml:"lock"`
+	LockTimeout  model.Duration  `yaml:"lock_timeout"`
+	Outputs      []outputConfig  `yaml:"outputs"`
+}
+
+type inputConfig struct {
+	Files   []string       `yaml:"files"`
+	Dir     string         `yaml:"dir"`
+	Pattern string         `yaml:"pattern"`
+	URL     string         `yaml:"url"`
+	Command []string       `yaml:"command"`
+	Timeout model.Duration `yaml:"timeout"`
+	MaxAge  model.Duration `yaml:"max_age"`
+	Lock    bool           `yaml:"lock"`
+
+	// Unix domain socket and HTTP path to request from it.
+	Unix        string `yaml:"unix"`
+	MetricsPath string `yaml:"metrics_path"`
+}
+
+type limitsConfig struct {
+	Series           int    `yaml:"series"`
+	SeriesPerFamily  int    `yaml:"series_per_family"`
+	LabelsPerSeries  int    `yaml:"labels_per_series"`
+	LabelValueLength int    `yaml:"label_value_length"`
+	InputBytes       int64  `yaml:"input_bytes"`
+	Policy           string `yaml:"policy"`
+}
+
+type outputConfig struct {
+	// File path, "-" for standard output.
+	Path    string `yaml:"path"`
+	Format  string `yaml:"format"`
+	Include string `yaml:"include"`
+	Exclude string `yaml:"exclude"`
+
+	// Permissions in octal notation.
+	Mode          string `yaml:"mode"`
+	Owner         string `yaml:"owner"`
+	Group         string `yaml:"group"`
+	OnlyIfChanged bool   `yaml:"only_if_changed"`
+	SyncDir       bool   `yaml:"sync_dir"`
+
+	// Defaults to true.
+	SyncFile *bool `yaml:"sync_file"`
+}
+
+// jobDefaults are command line settings applying to all jobs of a
+// configuration file.
+type jobDefaults struct {
+	spillThreshold int64
+	spillDir       string
+	lockTimeout    time.Duration
+	timeout        time.Duration
+}
+
+// compileNameRegexp compiles a regular expression matching whole names.
+func compileNameRegexp(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + pattern + ")$")
+}
+
+func newInputSource(cfg inputConfig, lockTimeout time.Duration) (inputSource, error) {
+	var kinds []string
+
+	if len(cfg.Files) > 0 {
+		kinds = append(kinds, "files")
+	}
+
+	if cfg.Dir != "" {
+		kinds = append(kinds, "dir")
+	}
+
+	if cfg.URL != "" {
+		kinds = append(kinds, "url")
+	}
+
+	if cfg.Unix != "" {
+		kinds = append(kinds, "unix")
+	}
+
+	if len(cfg.Command) > 0 {
+		kinds = append(kinds, "command")
+	}
+
+	if len(kinds) != 1 {
+		return inputSource{}, errors.New("exactly one of files, dir, url, unix or command is required")
+	}
+
+	result := inputSource{
+		files:   cfg.Files,
+		dir:     cfg.Dir,
+		url:     cfg.URL,
+		socket:  cfg.Unix,
+		command: cfg.Command,
+		opts: inputOptions{
+			maxAge:      time.Duration(cfg.MaxAge),
+			lock:        cfg.Lock,
+			lockTimeout: lockTimeout,
+		},
+	}
+
+	for _, i := range cfg.Files {
+		if i == stdinPlaceholder {
+			return inputSource{}, errors.New("standard input is not supported")
+		}
+	}
+
+	if cfg.Pattern != "" && cfg.Dir == "" {
+		return inputSource{}, errors.New("pattern requires dir")
+	}
+
+	if cfg.Dir != "" {
+		result.pattern = cfg.Pattern
+
+		if result.pattern == "" {
+			result.pattern = "[^.]*.prom"
+		}
+	}
+
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return inputSource{}, err
+		}
+
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return inputSource{}, fmt.Errorf("unsupported URL scheme %q", u.Scheme)
+		}
+	}
+
+	if cfg.Unix != "" {
+		result.metricsPath = cfg.MetricsPath
+
+		if result.metricsPath == "" {
+			result.metricsPath = defaultUnixSocketMetricsPath
+		} else if !strings.HasPrefix(result.metricsPath, "/") {
+			return inputSource{}, fmt.Errorf("metrics_path %q must start with a slash", cfg.MetricsPath)
+		}
+	} else if cfg.MetricsPath != "" {
+		return inputSource{}, errors.New("metrics_path requires unix")
+	}
+
+	if cfg.URL != "" || cfg.Unix != "" || len(cfg.Command) > 0 {
+		result.timeout = time.Duration(cfg.Timeout)
+	} else if cfg.Timeout != 0 {
+		return inputSource{}, errors.New("timeout requires url, unix or command")
+	}
+
+	return result, nil
+}
+
+func newOutputTarget(cfg outputConfig) (outputTarget, error) {
+	result := outputTarget{
+		path:   cfg.Path,
+		format: outputFormat(cfg.Format),
+		opts: outputOptions{
+			onlyIfChanged: cfg.OnlyIfChanged,
+			owner:         cfg.Owner,
+			group:         cfg.Group,
+			syncDir:       cfg.SyncDir,
+		},
+	}
+
+	if cfg.SyncFile != nil {
+		result.opts.skipFileSync = !*cfg.SyncFile
+	}
+
+	switch cfg.Path {
+	case "":
+		return outputTarget{}, errors.New("path is required")
+
+	case stdinPlaceholder:
+		result.path = ""
+	}
+
+	switch result.format {
+	case "":
+		result.format = outputFormatText
+
+	case outputFormatText, outputFormatOpenMetrics:
+
+	default:
+		return outputTarget{}, fmt.Errorf("unknown output format %q", cfg.Format)
+	}
+
+	var err error
+
+	if cfg.Include != "" {
+		if result.include, err = compileNameRegexp(cfg.Include); err != nil {
+			return outputTarget{}, fmt.Errorf("include: %w", err)
+		}
+	}
+
+	if cfg.Exclude != "" {
+		if result.exclude, err = compileNameRegexp(cfg.Exclude); err != nil {
+			return outputTarget{}, fmt.Errorf("exclude: %w", err)
+		}
+	}
+
+	if cfg.Mode != "" {
+		var mode fileModeFlag
+
+		if err := mode.Set(cfg.Mode); err != nil {
+			return outputTarget{}, err
+		}
+
+		result.opts.mode = os.FileMode(mode)
+	}
+
+	return result, nil
+}
+
+// newFamilyFilter returns a function accepting family names matching any of
+// the include expressions, if any, and none of the exclude expressions.
+func newFamilyFilter(include, exclude []string) (func(string) bool, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+
+	compile := func(patterns []string) ([]*regexp.Regexp, error) {
+		var result []*regexp.Regexp
+
+		for _, i := range patterns {
+			re, err := compileNameRegexp(i)
+			if err != nil {
+				return nil, err
+			}
+
+			result = append(result, re)
+		}
+
+		return result, nil
+	}
+
+	includeRe, err := compile(include)
+	if err != nil {
+		return nil, fmt.Errorf("include: %w", err)
+	}
+
+	excludeRe, err := compile(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("exclude: %w", err)
+	}
+
+	return func(name string) bool {
+		for _, re := range excludeRe {
+			if re.MatchString(name) {
+				return false
+			}
+		}
+
+		if len(includeRe) == 0 {
+			return true
+		}
+
+		for _, re := range includeRe {
+			if re.MatchString(name) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+func newMergeJob(cfg jobConfig, defaults jobDefaults) (*mergeJob, error) {
+	job := &mergeJob{
+		name: cfg.Name,
+		opts: mergeOptions{
+			inputMetrics: cfg.InputMetrics,
+			keepGoing:    cfg.KeepGoing,
+			selfMetrics:  cfg.SelfMetrics,
+			validate:     cfg.Validate,
+			limits: mergeLimits{
+				series:           cfg.Limits.Series,
+				seriesPerFamily:  cfg.Limits.SeriesPerFamily,
+				labelsPerSeries:  cfg.Limits.LabelsPerSeries,
+				labelValueLength: cfg.Limits.LabelValueLength,
+				inputBytes:       cfg.Limits.InputBytes,
+				policy:           limitPolicyFail,
+			},
+			spillThreshold: defaults.spillThreshold,
+			spillDir:       defaults.spillDir,
+			onConflict:     conflictPolicy(cfg.OnConflict),
+		},
+		showInputs:  cfg.ShowInputs,
+		lock:        cfg.Lock,
+		lockTimeout: defaults.lockTimeout,
+		timeout:     defaults.timeout,
+	}
+
+	if cfg.Timeout != 0 {
+		job.timeout = time.Duration(cfg.Timeout)
+	}
+
+	if cfg.LockTimeout != 0 {
+		job.lockTimeout = time.Duration(cfg.LockTimeout)
+	}
+
+	if len(cfg.Inputs) == 0 {
+		return nil, errors.New("no inputs")
+	}
+
+	for idx, i := range cfg.Inputs {
+		source, err := newInputSource(i, job.lockTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("inputs[%d]: %w", idx, err)
+		}
+
+		job.sources = append(job.sources, source)
+	}
+
+	var err error
+
+	if job.opts.familyFilter, err = newFamilyFilter(cfg.Include, cfg.Exclude); err != nil {
+		return nil, err
+	}
+
+	for idx, i := range cfg.Relabel {
+		rule, err := newRelabelRule(i)
+		if err != nil {
+			return nil, fmt.Errorf("relabel[%d]: %w", idx, err)
+		}
+
+		job.opts.relabel = append(job.opts.relabel, rule)
+	}
+
+	switch job.opts.onConflict {
+	case "", conflictPolicyFail, conflictPolicySkipInput, conflictPolicySkipFamily:
+	default:
+		return nil, fmt.Errorf("unknown conflict policy %q", cfg.OnConflict)
+	}
+
+	if cfg.Limits.Policy != "" {
+		if err := job.opts.limits.policy.Set(cfg.Limits.Policy); err != nil {
+			return nil, fmt.Errorf("limits: %w", err)
+		}
+	}
+
+	for idx, i := range cfg.Outputs {
+		target, err := newOutputTarget(i)
+		if err != nil {
+			return nil, fmt.Errorf("outputs[%d]: %w", idx, err)
+		}
+
+		job.outputs = append(job.outputs, target)
+	}
+
+	if job.lock && len(job.outputPaths()) == 0 {
+		return nil, errors.New("lock requires a file output")
+	}
+
+	return job, nil
+}
+
+// parseConfig decodes a configuration file. Unknown fields are rejected to
+// catch typos.
+func parseConfig(r io.Reader, defaults jobDefaults) ([]*mergeJob, error) {
+	var cfg fileConfig
+
+	dec := yaml.NewDecoder(r)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+
+	if len(cfg.Jobs) == 0 {
+		return nil, errors.New("no jobs")
+	}
+
+	var jobs []*mergeJob
+
+	names := map[string]struct{}{}
+
+	for idx, i := range cfg.Jobs {
+		if i.Name == "" {
+			return nil, fmt.Errorf("jobs[%d]: name is required", idx)
+		}
+
+		if strings.Contains(i.Name, "/") {
+			return nil, fmt.Errorf("jobs[%d]: name %q must not contain slashes", idx, i.Name)
+		}
+
+		if _, ok := names[i.Name]; ok {
+			return nil, fmt.Errorf("jobs[%d]: duplicate name %q", idx, i.Name)
+		}
+
+		names[i.Name] = struct{}{}
+
+		job, err := newMergeJob(i, defaults)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", i.Name, err)
+		}
+
+		config, err := yaml.Marshal(i)
+		if err != nil {
+			return nil, fmt.Errorf("job %q: %w", i.Name, err)
+		}
+
+		job.config = string(config)
+
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+// loadConfig reads the merge jobs from a configuration file.
+func loadConfig(path string, defaults jobDefaults) ([]*mergeJob, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	jobs, err := parseConfig(fh, defaults)
+	if err != nil {
+		return nil, &usageError{fmt.Errorf("config %s: %w", path, err)}
+	}
+
+	return jobs, nil
+}
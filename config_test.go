@@ -0,0 +1,347 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseConfig(t *testing.T) {
+	jobs, err := parseConfig(strings.NewReader(`
+jobs:
+  - name: node
+    inputs:
+      - dir: /var/lib/metrics
+      - files: [a.prom, b.prom]
+        max_age: 1h
+        lock: true
+      - url: http://localhost:9100/metrics
+        timeout: 5s
+      - command: [/usr/local/bin/collect-raid, --flag]
+        timeout: 1m
+      - unix: /run/app.sock
+    include: ["node_.*"]
+    exclude: ["node_scrape_.*"]
+    relabel:
+      - action: labeldrop
+        regex: instance
+    on_conflict: skip_family
+    limits:
+      series: 1000
+      policy: truncate
+    timeout: 30s
+    lock: true
+    outputs:
+      - path: /tmp/all.prom
+        mode: "0640"
+        only_if_changed: true
+        sync_file: false
+      - path: "-"
+        format: openmetrics
+        include: node_load.*
+  - name: other
+    inputs:
+      - files: [c.prom]
+    outputs:
+      - path: /tmp/other.prom
+`), jobDefaults{lockTimeout: time.Minute, timeout: time.Hour, spillThreshold: 1024})
+	if err != nil {
+		t.Fatalf("parseConfig() failed: %v", err)
+	}
+
+	if len(jobs) != 2 {
+		t.Fatalf("parseConfig() returned %d jobs, want 2", len(jobs))
+	}
+
+	job := jobs[0]
+
+	if diff := cmp.Diff(job.sources, []inputSource{
+		{dir: "/var/lib/metrics", pattern: "[^.]*.prom", opts: inputOptions{lockTimeout: time.Minute}},
+		{files: []string{"a.prom", "b.prom"}, opts: inputOptions{maxAge: time.Hour, lock: true, lockTimeout: time.Minute}},
+		{url: "http://localhost:9100/metrics", timeout: 5 * time.Second, opts: inputOptions{lockTimeout: time.Minute}},
+		{command: []string{"/usr/local/bin/collect-raid", "--flag"}, timeout: time.Minute, opts: inputOptions{lockTimeout: time.Minute}},
+		{socket: "/run/app.sock", metricsPath: "/metrics", opts: inputOptions{lockTimeout: time.Minute}},
+	}, cmp.AllowUnexported(inputSource{}, inputOptions{})); diff != "" {
+		t.Errorf("Input sources difference (-got +want):\n%s", diff)
+	}
+
+	for name, want := range map[string]bool{
+		"node_load1":          true,
+		"node_scrape_seconds": false,
+		"go_goroutines":       false,
+	} {
+		if got := job.opts.familyFilter(name); got != want {
+			t.Errorf("familyFilter(%q) returned %v, want %v", name, got, want)
+		}
+	}
+
+	if got := len(job.opts.relabel); got != 1 {
+		t.Errorf("Got %d relabel rules, want 1", got)
+	}
+
+	if got, want := job.opts.conflictPolicy(), conflictPolicySkipFamily; got != want {
+		t.Errorf("Got conflict policy %q, want %q", got, want)
+	}
+
+	if got := job.opts.limits; got.series != 1000 || got.policy != limitPolicyTruncate {
+		t.Errorf("Got limits %+v", got)
+	}
+
+	if job.timeout != 30*time.Second || !job.lock || job.opts.spillThreshold != 1024 {
+		t.Errorf("Got timeout %v, lock %v, spill threshold %d", job.timeout, job.lock, job.opts.spillThreshold)
+	}
+
+	if diff := cmp.Diff(job.outputPaths(), []string{"/tmp/all.prom"}); diff != "" {
+		t.Errorf("outputPaths() difference (-got +want):\n%s", diff)
+	}
+
+	if got := job.outputs[0].opts; got != (outputOptions{mode: 0o640, onlyIfChanged: true, skipFileSync: true}) {
+		t.Errorf("Got output options %+v", got)
+	}
+
+	if got := job.outputs[1]; got.path != "" || got.format != outputFormatOpenMetrics || !got.keep("node_load5") || got.keep("node_cpu") {
+		t.Errorf("Got unexpected output target %+v", got)
+	}
+
+	if got := jobs[1].timeout; got != time.Hour {
+		t.Errorf("Got default timeout %v, want %v", got, time.Hour)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:    "empty",
+			wantErr: regexp.MustCompile(`^no jobs$`),
+		},
+		{
+			name:    "unknown field",
+			config:  "jobs:\n  - name: a\n    inptus: []\n",
+			wantErr: regexp.MustCompile(`field inptus not found`),
+		},
+		{
+			name:    "missing name",
+			config:  "jobs:\n  - inputs: [{files: [a]}]\n",
+			wantErr: regexp.MustCompile(`^jobs\[0\]: name is required$`),
+		},
+		{
+			name:    "duplicate name",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n  - name: a\n    inputs: [{files: [a]}]\n",
+			wantErr: regexp.MustCompile(`^jobs\[1\]: duplicate name "a"$`),
+		},
+		{
+			name:    "name with slash",
+			config:  "jobs:\n  - name: a/b\n",
+			wantErr: regexp.MustCompile(`must not contain slashes$`),
+		},
+		{
+			name:    "no inputs",
+			config:  "jobs:\n  - name: a\n",
+			wantErr: regexp.MustCompile(`^job "a": no inputs$`),
+		},
+		{
+			name:    "ambiguous input",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a], dir: b}]\n",
+			wantErr: regexp.MustCompile(`^job "a": inputs\[0\]: exactly one of files, dir, url, unix or command is required$`),
+		},
+		{
+			name:    "stdin",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [\"-\"]}]\n",
+			wantErr: regexp.MustCompile(`^job "a": inputs\[0\]: standard input is not supported$`),
+		},
+		{
+			name:    "url scheme",
+			config:  "jobs:\n  - name: a\n    inputs: [{url: \"ftp://host/metrics\"}]\n",
+			wantErr: regexp.MustCompile(`unsupported URL scheme "ftp"$`),
+		},
+		{
+			name:    "timeout without url, unix or command",
+			config:  "jobs:\n  - name: a\n    inputs: [{dir: a, timeout: 1s}]\n",
+			wantErr: regexp.MustCompile(`^job "a": inputs\[0\]: timeout requires url, unix or command$`),
+		},
+		{
+			name:    "metrics path without unix",
+			config:  "jobs:\n  - name: a\n    inputs: [{url: \"http://host/\", metrics_path: /m}]\n",
+			wantErr: regexp.MustCompile(`^job "a": inputs\[0\]: metrics_path requires unix$`),
+		},
+		{
+			name:    "relative metrics path",
+			config:  "jobs:\n  - name: a\n    inputs: [{unix: /run/app.sock, metrics_path: metrics}]\n",
+			wantErr: regexp.MustCompile(`^job "a": inputs\[0\]: metrics_path "metrics" must start with a slash$`),
+		},
+		{
+			name:    "bad duration",
+			config:  "jobs:\n  - name: a\n    timeout: soon\n",
+			wantErr: regexp.MustCompile(`not a valid duration`),
+		},
+		{
+			name:    "bad include",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    include: [\"(\"]\n",
+			wantErr: regexp.MustCompile(`^job "a": include: `),
+		},
+		{
+			name:    "bad relabel",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    relabel: [{action: keep}]\n",
+			wantErr: regexp.MustCompile(`^job "a": relabel\[0\]: keep action requires source_labels$`),
+		},
+		{
+			name:    "conflict policy",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    on_conflict: ignore\n",
+			wantErr: regexp.MustCompile(`^job "a": unknown conflict policy "ignore"$`),
+		},
+		{
+			name:    "limit policy",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    limits: {policy: ignore}\n",
+			wantErr: regexp.MustCompile(`^job "a": limits: `),
+		},
+		{
+			name:    "output without path",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    outputs: [{format: text}]\n",
+			wantErr: regexp.MustCompile(`^job "a": outputs\[0\]: path is required$`),
+		},
+		{
+			name:    "output format",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    outputs: [{path: x, format: json}]\n",
+			wantErr: regexp.MustCompile(`^job "a": outputs\[0\]: unknown output format "json"$`),
+		},
+		{
+			name:    "output mode",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    outputs: [{path: x, mode: \"999\"}]\n",
+			wantErr: regexp.MustCompile(`^job "a": outputs\[0\]: invalid file mode "999"$`),
+		},
+		{
+			name:    "lock without file output",
+			config:  "jobs:\n  - name: a\n    inputs: [{files: [a]}]\n    lock: true\n    outputs: [{path: \"-\"}]\n",
+			wantErr: regexp.MustCompile(`^job "a": lock requires a file output$`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseConfig(strings.NewReader(tc.config), jobDefaults{})
+
+			if err == nil || !tc.wantErr.MatchString(err.Error()) {
+				t.Errorf("parseConfig() failed with %v, want match for %q", err, tc.wantErr.String())
+			}
+		})
+	}
+}
+
+func TestLoadConfigUsageError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+
+	if err := os.WriteFile(path, []byte("jobs: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var usageErr *usageError
+
+	if _, err := loadConfig(path, jobDefaults{}); !errors.As(err, &usageErr) {
+		t.Errorf("loadConfig() failed with %v, want usage error", err)
+	}
+}
+
+func TestRunConfigJobs(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"a.prom": "# TYPE size gauge\nsize{dev=\"a\",tmp=\"x\"} 1\n# TYPE other gauge\nother 1\n",
+		"b.prom": "# TYPE size counter\nsize 2\n",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	jobs, err := parseConfig(strings.NewReader(`
+jobs:
+  - name: first
+    inputs:
+      - dir: `+tmpdir+`
+    include: [size]
+    relabel:
+      - action: labeldrop
+        regex: tmp
+    on_conflict: skip_family
+    outputs:
+      - path: `+filepath.Join(tmpdir, "first.out")+`
+  - name: second
+    inputs:
+      - files: [`+filepath.Join(tmpdir, "a.prom")+`]
+    outputs:
+      - path: `+filepath.Join(tmpdir, "second.out")+`
+        exclude: size
+`), jobDefaults{})
+	if err != nil {
+		t.Fatalf("parseConfig() failed: %v", err)
+	}
+
+	if err := runJobs(context.Background(), jobs); err != nil {
+		t.Errorf("runJobs() failed: %v", err)
+	}
+
+	for name, want := range map[string]string{
+		"first.out":  "# TYPE size gauge\nsize{dev=\"a\"} 1\n",
+		"second.out": "# TYPE other gauge\nother 1\n",
+	} {
+		if got, err := os.ReadFile(filepath.Join(tmpdir, name)); err != nil {
+			t.Errorf("ReadFile() failed: %v", err)
+		} else if diff := cmp.Diff(string(got), want); diff != "" {
+			t.Errorf("%s content difference (-got +want):\n%s", name, diff)
+		}
+	}
+}
+
+func TestConfigFilesNotExecuted(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test command requires touch")
+	}
+
+	tmpdir := t.TempDir()
+	marker := filepath.Join(tmpdir, "executed")
+
+	if err := os.WriteFile(filepath.Join(tmpdir, "a.prom"), []byte("up 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	jobs, err := parseConfig(strings.NewReader(`
+jobs:
+  - name: test
+    inputs:
+      - dir: `+tmpdir+`
+      # Read as a file named like a command
+      - files: ["exec:touch `+marker+`"]
+    keep_going: true
+    outputs:
+      - path: `+filepath.Join(tmpdir, "out")+`
+`), jobDefaults{})
+	if err != nil {
+		t.Fatalf("parseConfig() failed: %v", err)
+	}
+
+	var partialErr *partialFailureError
+
+	if err := runJobs(context.Background(), jobs); !errors.As(err, &partialErr) || !errors.Is(partialErr.failures[0], os.ErrNotExist) {
+		t.Errorf("runJobs() failed with %v, want missing file", err)
+	}
+
+	if _, err := os.Stat(marker); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Command was executed: %v", err)
+	}
+
+	if got, err := os.ReadFile(filepath.Join(tmpdir, "out")); err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	} else if diff := cmp.Diff(string(got), "# TYPE up untyped\nup 1\n"); diff != "" {
+		t.Errorf("Output difference (-got +want):\n%s", diff)
+	}
+}
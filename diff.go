@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+type diffStatus string
+
+const (
+	diffAdded   diffStatus = "added"
+	diffRemoved diffStatus = "removed"
+	diffChanged diffStatus = "changed"
+)
+
+var diffStatusSymbol = map[diffStatus]string{
+	diffAdded:   "+",
+	diffRemoved: "-",
+	diffChanged: "~",
+}
+
+// seriesDiff describes a changed sample. Values are formatted as strings to
+// support NaN and infinity in JSON.
+type seriesDiff struct {
+	Series string     `json:"series"`
+	Status diffStatus `json:"status"`
+	Old    string     `json:"old,omitempty"`
+	New    string     `json:"new,omitempty"`
+	Delta  string     `json:"delta,omitempty"`
+}
+
+type familyDiff struct {
+	Name    string       `json:"name"`
+	Status  diffStatus   `json:"status"`
+	OldType string       `json:"old_type,omitempty"`
+	NewType string       `json:"new_type,omitempty"`
+	OldHelp *string      `json:"old_help,omitempty"`
+	NewHelp *string      `json:"new_help,omitempty"`
+	Series  []seriesDiff `json:"series,omitempty"`
+}
+
+func sampleValues(samples []sample) (map[string]float64, []string) {
+	values := make(map[string]float64, len(samples))
+	order := make([]string, 0, len(samples))
+
+	for _, i := range samples {
+		if _, ok := values[i.series]; !ok {
+			order = append(order, i.series)
+		}
+
+		values[i.series] = i.value
+	}
+
+	return values, order
+}
+
+// diffSamples compares the samples of two families. Samples with identical
+// values, including NaN, are not reported.
+func diffSamples(oldSamples, newSamples []sample) []seriesDiff {
+	var result []seriesDiff
+
+	oldValues, oldOrder := sampleValues(oldSamples)
+	newValues, newOrder := sampleValues(newSamples)
+
+	for _, series := range oldOrder {
+		if _, ok := newValues[series]; !ok {
+			result = append(result, seriesDiff{
+				Series: series,
+				Status: diffRemoved,
+				Old:    formatValue(oldValues[series]),
+			})
+		}
+	}
+
+	for _, series := range newOrder {
+		newValue := newValues[series]
+
+		oldValue, ok := oldValues[series]
+		if !ok {
+			result = append(result, seriesDiff{
+				Series: series,
+				Status: diffAdded,
+				New:    formatValue(newValue),
+			})
+			continue
+		}
+
+		if formatValue(oldValue) != formatValue(newValue) {
+			result = append(result, seriesDiff{
+				Series: series,
+				Status: diffChanged,
+				Old:    formatValue(oldValue),
+				New:    formatValue(newValue),
+				Delta:  formatValue(newValue - oldValue),
+			})
+		}
+	}
+
+	sort.SliceStable(result, func(a, b int) bool {
+		return result[a].Series < result[b].Series
+	})
+
+	return result
+}
+
+// diffInputs compares the families of two parsed inputs. The result is sorted
+// by family name.
+func diffInputs(oldInput, newInput parsedInput) []familyDiff {
+	var result []familyDiff
+
+	names := map[string]struct{}{}
+
+	for name := range oldInput.families {
+		names[name] = struct{}{}
+	}
+
+	for name := range newInput.families {
+		names[name] = struct{}{}
+	}
+
+	for name := range names {
+		oldMF := oldInput.families[name]
+		newMF := newInput.families[name]
+
+		d := familyDiff{
+			Name: name,
+		}
+
+		switch {
+		case oldMF == nil:
+			d.Status = diffAdded
+			d.NewType = familyTypeName(newMF)
+			d.Series = diffSamples(nil, familySamples(newMF))
+
+		case newMF == nil:
+			d.Status = diffRemoved
+			d.OldType = familyTypeName(oldMF)
+			d.Series = diffSamples(familySamples(oldMF), nil)
+
+		default:
+			d.Status = diffChanged
+			d.Series = diffSamples(familySamples(oldMF), familySamples(newMF))
+
+			if oldMF.GetType() != newMF.GetType() {
+				d.OldType = familyTypeName(oldMF)
+				d.NewType = familyTypeName(newMF)
+			}
+
+			if oldHelp, newHelp := oldMF.GetHelp(), newMF.GetHelp(); oldHelp != newHelp {
+				d.OldHelp = &oldHelp
+				d.NewHelp = &newHelp
+			}
+
+			if d.OldType == "" && d.OldHelp == nil && len(d.Series) == 0 {
+				continue
+			}
+		}
+
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(a, b int) bool {
+		return result[a].Name < result[b].Name
+	})
+
+	return result
+}
+
+func writeDiffText(w io.Writer, diffs []familyDiff) error {
+	for _, d := range diffs {
+		fmt.Fprintf(w, "%s %s", diffStatusSymbol[d.Status], d.Name)
+
+		switch d.Status {
+		case diffAdded:
+			fmt.Fprintf(w, " (%s)", d.NewType)
+		case diffRemoved:
+			fmt.Fprintf(w, " (%s)", d.OldType)
+		}
+
+		io.WriteString(w, "\n")
+
+		if d.Status == diffChanged {
+			if d.OldType != "" {
+				fmt.Fprintf(w, "    type: %s -> %s\n", d.OldType, d.NewType)
+			}
+
+			if d.OldHelp != nil {
+				fmt.Fprintf(w, "    help: %s -> %s\n", strconv.Quote(*d.OldHelp), strconv.Quote(*d.NewHelp))
+			}
+		}
+
+		for _, s := range d.Series {
+			fmt.Fprintf(w, "  %s %s ", diffStatusSymbol[s.Status], s.Series)
+
+			switch s.Status {
+			case diffAdded:
+				io.WriteString(w, s.New)
+			case diffRemoved:
+				io.WriteString(w, s.Old)
+			case diffChanged:
+				fmt.Fprintf(w, "%s -> %s (delta %s)", s.Old, s.New, s.Delta)
+			}
+
+			io.WriteString(w, "\n")
+		}
+	}
+
+	return nil
+}
+
+func writeDiffJSON(w io.Writer, diffs []familyDiff) error {
+	if diffs == nil {
+		diffs = []familyDiff{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(diffs)
+}
+
+type diffCommand struct {
+	format string
+}
+
+var _ subcommand = (*diffCommand)(nil)
+
+func (c *diffCommand) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "format", "text", `Output format ("text" or "json")`)
+}
+
+func (c *diffCommand) run(ctx context.Context, fs *flag.FlagSet) error {
+	var write func(io.Writer, []familyDiff) error
+
+	switch c.format {
+	case "text":
+		write = writeDiffText
+	case "json":
+		write = writeDiffJSON
+	default:
+		return &usageError{fmt.Errorf("unknown format %q", c.format)}
+	}
+
+	if fs.NArg() != 2 {
+		return &usageError{errors.New("exactly two inputs are required")}
+	}
+
+	var parsed []parsedInput
+
+	for _, w := range inputWrappersFromArgs(fs.Args(), inputOptions{}) {
+		p, err := readMetricFamilies(ctx, w, nil)
+		if err != nil {
+			return err
+		}
+
+		parsed = append(parsed, p)
+	}
+
+	return write(stdoutWriter, diffInputs(parsed[0], parsed[1]))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffInputs(t *testing.T) {
+	oldInput := `# HELP req Requests.
+# TYPE req counter
+req{code="200"} 10
+req{code="500"} 1
+# TYPE gone gauge
+gone 1
+# TYPE same gauge
+same NaN
+# TYPE kind gauge
+kind 1
+`
+
+	newInput := `# HELP req Total requests.
+# TYPE req counter
+req{code="200"} 15
+req{code="404"} 2
+# TYPE fresh gauge
+fresh 3
+# TYPE same gauge
+same NaN
+# TYPE kind untyped
+kind 1
+`
+
+	for _, tc := range []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "identical",
+			old:  oldInput,
+			new:  oldInput,
+		},
+		{
+			name: "changes",
+			old:  oldInput,
+			new:  newInput,
+			want: `+ fresh (gauge)
+  + fresh 3
+- gone (gauge)
+  - gone 1
+~ kind
+    type: gauge -> untyped
+~ req
+    help: "Requests." -> "Total requests."
+  ~ req{code="200"} 10 -> 15 (delta 5)
+  + req{code="404"} 2
+  - req{code="500"} 1
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			diffs := diffInputs(mustParseInput(t, "old", tc.old), mustParseInput(t, "new", tc.new))
+
+			var buf strings.Builder
+
+			if err := writeDiffText(&buf, diffs); err != nil {
+				t.Errorf("writeDiffText() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(buf.String(), tc.want); diff != "" {
+				t.Errorf("Diff difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteDiffJSON(t *testing.T) {
+	diffs := diffInputs(mustParseInput(t, "old", "a 1\nb 2\n"), mustParseInput(t, "new", "a 1.5\n"))
+
+	var buf strings.Builder
+
+	if err := writeDiffJSON(&buf, diffs); err != nil {
+		t.Errorf("writeDiffJSON() failed: %v", err)
+	}
+
+	want := `[
+  {
+    "name": "a",
+    "status": "changed",
+    "series": [
+      {
+        "series": "a",
+        "status": "changed",
+        "old": "1",
+        "new": "1.5",
+        "delta": "0.5"
+      }
+    ]
+  },
+  {
+    "name": "b",
+    "status": "removed",
+    "old_type": "untyped",
+    "series": [
+      {
+        "series": "b",
+        "status": "removed",
+        "old": "2"
+      }
+    ]
+  }
+]
+`
+
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("writeDiffJSON() difference (-got +want):\n%s", diff)
+	}
+}
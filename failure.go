@@ -0,0 +1,325 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// Exit codes used to distinguish the cause of a failure.
+const (
+	exitCodeFailure        = 1
+	exitCodeUsage          = 2
+	exitCodePartialFailure = 3
+	exitCodeInputRead      = 4
+	exitCodeParse          = 5
+	exitCodeMergeConflict  = 6
+	exitCodeOutput         = 7
+	exitCodeValidation     = 8
+	exitCodeLimit          = 9
+)
+
+type failureKind string
+
+const (
+	failureKindOther         failureKind = "other"
+	failureKindUsage         failureKind = "usage"
+	failureKindInputRead     failureKind = "input_read"
+	failureKindParse         failureKind = "parse"
+	failureKindMergeConflict failureKind = "merge_conflict"
+	failureKindOutput        failureKind = "output"
+	failureKindValidation    failureKind = "validation"
+	failureKindLimit         failureKind = "limit"
+)
+
+var exitCodeByFailureKind = map[failureKind]int{
+	failureKindOther:         exitCodeFailure,
+	failureKindUsage:         exitCodeUsage,
+	failureKindInputRead:     exitCodeInputRead,
+	failureKindParse:         exitCodeParse,
+	failureKindMergeConflict: exitCodeMergeConflict,
+	failureKindOutput:        exitCodeOutput,
+	failureKindValidation:    exitCodeValidation,
+	failureKindLimit:         exitCodeLimit,
+}
+
+// usageError signals invalid command line arguments.
+type usageError struct {
+	err error
+}
+
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e *usageError) Unwrap() error {
+	return e.err
+}
+
+// inputError is returned when an input can't be read or parsed. The wrapped
+// error already contains the input name.
+type inputError struct {
+	name string
+	err  error
+}
+
+func (e *inputError) Error() string {
+	return e.err.Error()
+}
+
+func (e *inputError) Unwrap() error {
+	return e.err
+}
+
+// mergeConflictError is returned when a family can't be combined with an
+// existing family of the same name.
+type mergeConflictError struct {
+	family string
+	input  string
+	err    error
+}
+
+func (e *mergeConflictError) Error() string {
+	return fmt.Sprintf("family %q from %q: %v", e.family, e.input, e.err)
+}
+
+func (e *mergeConflictError) Unwrap() error {
+	return e.err
+}
+
+type outputError struct {
+	err error
+}
+
+func (e *outputError) Error() string {
+	return fmt.Sprintf("Writing output failed: %v", e.err)
+}
+
+func (e *outputError) Unwrap() error {
+	return e.err
+}
+
+// validationError is returned when metrics have problems of at least the
+// severity deemed fatal.
+type validationError struct {
+	input    string
+	problems int
+}
+
+func (e *validationError) Error() string {
+	msg := fmt.Sprintf("validation found %d problem(s)", e.problems)
+
+	if e.input != "" {
+		msg = e.input + ": " + msg
+	}
+
+	return msg
+}
+
+// limitError is returned when an input exceeds a configured limit.
+type limitError struct {
+	input  string
+	family string
+	err    error
+}
+
+func (e *limitError) Error() string {
+	if e.family != "" {
+		return fmt.Sprintf("%s: family %q: %v", e.input, e.family, e.err)
+	}
+
+	return fmt.Sprintf("%s: %v", e.input, e.err)
+}
+
+func (e *limitError) Unwrap() error {
+	return e.err
+}
+
+// partialFailureError is returned when the output was written, but some
+// inputs had to be excluded.
+type partialFailureError struct {
+	failures []error
+}
+
+func (e *partialFailureError) Error() string {
+	return fmt.Sprintf("%d input(s) skipped due to errors", len(e.failures))
+}
+
+// isPartialFailure reports whether all outputs were written despite the error,
+// i.e. all joined errors are partial failures.
+func isPartialFailure(err error) bool {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+
+		for _, i := range errs {
+			if !isPartialFailure(i) {
+				return false
+			}
+		}
+
+		return len(errs) > 0
+	}
+
+	var partialErr *partialFailureError
+
+	return errors.As(err, &partialErr)
+}
+
+// failureEntry describes a single failure in the machine-readable report.
+type failureEntry struct {
+	Kind    failureKind `json:"kind"`
+	Input   string      `json:"input,omitempty"`
+	Family  string      `json:"family,omitempty"`
+	Line    int         `json:"line,omitempty"`
+	Column  int         `json:"column,omitempty"`
+	Context string      `json:"context,omitempty"`
+	Message string      `json:"message"`
+}
+
+func newFailureEntry(err error) failureEntry {
+	entry := failureEntry{
+		Kind:    failureKindOther,
+		Message: err.Error(),
+	}
+
+	var usageErr *usageError
+	var inputErr *inputError
+	var conflictErr *mergeConflictError
+	var outputErr *outputError
+	var validationErr *validationError
+	var limitErr *limitError
+	var pathErr *fs.PathError
+
+	switch {
+	case errors.As(err, &usageErr), errors.Is(err, filepath.ErrBadPattern):
+		entry.Kind = failureKindUsage
+
+	case errors.As(err, &inputErr):
+		var parseErr expfmt.ParseError
+		var contextErr *parseError
+
+		entry.Input = inputErr.name
+
+		if errors.As(err, &parseErr) {
+			entry.Kind = failureKindParse
+			entry.Line = parseErr.Line
+			entry.Message = parseErr.Msg
+
+			if errors.As(err, &contextErr) {
+				entry.Column = contextErr.column
+				entry.Context = contextErr.content
+			}
+		} else {
+			entry.Kind = failureKindInputRead
+		}
+
+	case errors.As(err, &conflictErr):
+		entry.Kind = failureKindMergeConflict
+		entry.Input = conflictErr.input
+		entry.Family = conflictErr.family
+
+	case errors.As(err, &outputErr):
+		entry.Kind = failureKindOutput
+
+	case errors.As(err, &validationErr):
+		entry.Kind = failureKindValidation
+		entry.Input = validationErr.input
+
+	case errors.As(err, &limitErr):
+		entry.Kind = failureKindLimit
+		entry.Input = limitErr.input
+		entry.Family = limitErr.family
+
+	case errors.As(err, &pathErr):
+		entry.Kind = failureKindInputRead
+		entry.Input = pathErr.Path
+	}
+
+	return entry
+}
+
+type failureReport struct {
+	ExitCode int            `json:"exit_code"`
+	Failures []failureEntry `json:"failures"`
+}
+
+// newFailureReport classifies the error returned from a run. A nil error
+// produces an empty report with a zero exit code.
+func newFailureReport(err error) failureReport {
+	report := failureReport{
+		Failures: []failureEntry{},
+	}
+
+	if err == nil {
+		return report
+	}
+
+	// Errors of multiple jobs are joined. The first determines the exit code.
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for idx, i := range joined.Unwrap() {
+			sub := newFailureReport(i)
+
+			if idx == 0 {
+				report.ExitCode = sub.ExitCode
+			}
+
+			report.Failures = append(report.Failures, sub.Failures...)
+		}
+
+		return report
+	}
+
+	var partialErr *partialFailureError
+
+	if errors.As(err, &partialErr) {
+		report.ExitCode = exitCodePartialFailure
+
+		for _, i := range partialErr.failures {
+			report.Failures = append(report.Failures, newFailureEntry(i))
+		}
+	} else {
+		entry := newFailureEntry(err)
+
+		report.ExitCode = exitCodeByFailureKind[entry.Kind]
+		report.Failures = append(report.Failures, entry)
+	}
+
+	return report
+}
+
+func (r failureReport) write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(r)
+}
+
+// exitCodesHelp describes the exit codes for the usage text.
+func exitCodesHelp() string {
+	var sb strings.Builder
+
+	for _, i := range []struct {
+		code int
+		desc string
+	}{
+		{exitCodeFailure, "other failure"},
+		{exitCodeUsage, "invalid command line usage"},
+		{exitCodePartialFailure, "inputs skipped with --keep-going, output written"},
+		{exitCodeInputRead, "reading an input failed"},
+		{exitCodeParse, "parsing an input failed"},
+		{exitCodeMergeConflict, "metric families could not be merged"},
+		{exitCodeOutput, "writing the output failed"},
+		{exitCodeValidation, "metrics failed validation"},
+		{exitCodeLimit, "a limit was exceeded"},
+	} {
+		fmt.Fprintf(&sb, "  %d  %s\n", i.code, i.desc)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/expfmt"
+)
+
+func TestNewFailureReport(t *testing.T) {
+	parseErr := &inputError{
+		name: "bad.prom",
+		err:  fmt.Errorf("bad.prom: %w", expfmt.ParseError{Line: 7, Msg: "expected float"}),
+	}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want failureReport
+	}{
+		{
+			name: "success",
+			want: failureReport{Failures: []failureEntry{}},
+		},
+		{
+			name: "other",
+			err:  errors.New("test"),
+			want: failureReport{
+				ExitCode: exitCodeFailure,
+				Failures: []failureEntry{
+					{Kind: failureKindOther, Message: "test"},
+				},
+			},
+		},
+		{
+			name: "usage",
+			err:  &usageError{errors.New("bad flag")},
+			want: failureReport{
+				ExitCode: exitCodeUsage,
+				Failures: []failureEntry{
+					{Kind: failureKindUsage, Message: "bad flag"},
+				},
+			},
+		},
+		{
+			name: "read",
+			err:  &inputError{name: "missing.prom", err: &os.PathError{Op: "open", Path: "missing.prom", Err: os.ErrNotExist}},
+			want: failureReport{
+				ExitCode: exitCodeInputRead,
+				Failures: []failureEntry{
+					{Kind: failureKindInputRead, Input: "missing.prom", Message: "open missing.prom: file does not exist"},
+				},
+			},
+		},
+		{
+			name: "parse",
+			err:  parseErr,
+			want: failureReport{
+				ExitCode: exitCodeParse,
+				Failures: []failureEntry{
+					{Kind: failureKindParse, Input: "bad.prom", Line: 7, Message: "expected float"},
+				},
+			},
+		},
+		{
+			name: "validation",
+			err:  &validationError{input: "merged", problems: 2},
+			want: failureReport{
+				ExitCode: exitCodeValidation,
+				Failures: []failureEntry{
+					{Kind: failureKindValidation, Input: "merged", Message: "merged: validation found 2 problem(s)"},
+				},
+			},
+		},
+		{
+			name: "conflict",
+			err:  &mergeConflictError{family: "size", input: "b.prom", err: errors.New("type mismatch")},
+			want: failureReport{
+				ExitCode: exitCodeMergeConflict,
+				Failures: []failureEntry{
+					{Kind: failureKindMergeConflict, Input: "b.prom", Family: "size", Message: `family "size" from "b.prom": type mismatch`},
+				},
+			},
+		},
+		{
+			name: "output",
+			err:  &outputError{errors.New("disk full")},
+			want: failureReport{
+				ExitCode: exitCodeOutput,
+				Failures: []failureEntry{
+					{Kind: failureKindOutput, Message: "Writing output failed: disk full"},
+				},
+			},
+		},
+		{
+			name: "partial",
+			err:  &partialFailureError{failures: []error{parseErr}},
+			want: failureReport{
+				ExitCode: exitCodePartialFailure,
+				Failures: []failureEntry{
+					{Kind: failureKindParse, Input: "bad.prom", Line: 7, Message: "expected float"},
+				},
+			},
+		},
+		{
+			name: "multiple jobs",
+			err: errors.Join(
+				fmt.Errorf("job %q: %w", "first", &outputError{errors.New("disk full")}),
+				fmt.Errorf("job %q: %w", "second", &partialFailureError{failures: []error{parseErr}}),
+			),
+			want: failureReport{
+				ExitCode: exitCodeOutput,
+				Failures: []failureEntry{
+					{Kind: failureKindOutput, Message: `job "first": Writing output failed: disk full`},
+					{Kind: failureKindParse, Input: "bad.prom", Line: 7, Message: "expected float"},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := newFailureReport(tc.err)
+
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("newFailureReport() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFailureReportWrite(t *testing.T) {
+	var buf strings.Builder
+
+	report := newFailureReport(&outputError{errors.New("disk full")})
+
+	if err := report.write(&buf); err != nil {
+		t.Errorf("write() failed: %v", err)
+	}
+
+	want := `{
+  "exit_code": 7,
+  "failures": [
+    {
+      "kind": "output",
+      "message": "Writing output failed: disk full"
+    }
+  ]
+}
+`
+
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("write() difference (-got +want):\n%s", diff)
+	}
+}
+
+func TestIsPartialFailure(t *testing.T) {
+	partial := fmt.Errorf("job %q: %w", "a", &partialFailureError{failures: []error{errors.New("test")}})
+	fatal := &outputError{errors.New("test")}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil"},
+		{name: "partial", err: partial, want: true},
+		{name: "fatal", err: fatal},
+		{name: "joined partial", err: errors.Join(partial, partial), want: true},
+		{name: "joined mixed", err: errors.Join(fatal, partial)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isPartialFailure(tc.err); got != tc.want {
+				t.Errorf("isPartialFailure() returned %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+const lockPollInterval = 100 * time.Millisecond
+
+// flockFile acquires an advisory lock on the file, waiting until the context
+// is cancelled. The lock is released when the file is closed.
+func flockFile(ctx context.Context, fh *os.File, exclusive bool) error {
+	how := unix.LOCK_SH
+
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+
+	// Unlike Fd the raw connection keeps the descriptor from being closed
+	// concurrently, e.g. when reading is cancelled.
+	rc, err := fh.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	for {
+		if ctrlErr := rc.Control(func(fd uintptr) {
+			err = unix.Flock(int(fd), how|unix.LOCK_NB)
+		}); ctrlErr != nil {
+			return fmt.Errorf("%s: %w", fh.Name(), ctrlErr)
+		}
+
+		if err == nil {
+			return nil
+		}
+
+		if !errors.Is(err, unix.EWOULDBLOCK) {
+			return &os.PathError{Op: "flock", Path: fh.Name(), Err: err}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s: waiting for lock: %w", fh.Name(), ctx.Err())
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+)
+
+func flockFile(ctx context.Context, fh *os.File, exclusive bool) error {
+	return errors.New("file locking is not supported on Windows")
+}
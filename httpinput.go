@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultHTTPInputTimeout = 10 * time.Second
+
+// unixSocketInputPrefix marks inputs fetched via HTTP from a server listening
+// on a Unix domain socket, e.g. "unix:/run/app.sock:/metrics".
+const unixSocketInputPrefix = "unix:"
+
+const defaultUnixSocketMetricsPath = "/metrics"
+
+// unixSocketInput splits an input of the form "unix:<socket>[:<path>]" into the
+// socket and the HTTP path. The path must start with a slash and defaults to
+// "/metrics".
+func unixSocketInput(input string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(input, unixSocketInputPrefix)
+	if !ok {
+		return "", "", false
+	}
+
+	if idx := strings.LastIndex(rest, ":/"); idx >= 0 {
+		return rest[:idx], rest[idx+1:], true
+	}
+
+	return rest, defaultUnixSocketMetricsPath, true
+}
+
+// httpInputWrapper fetches metrics in the text format via HTTP.
+type httpInputWrapper struct {
+	// Name of the input, the URL if empty.
+	name string
+
+	url     string
+	client  *http.Client
+	timeout time.Duration
+}
+
+var _ inputWrapper = (*httpInputWrapper)(nil)
+
+// newUnixSocketInputWrapper returns an input fetching metrics via HTTP from a
+// server listening on a Unix domain socket.
+func newUnixSocketInputWrapper(socket, path string, timeout time.Duration) *httpInputWrapper {
+	var dialer net.Dialer
+
+	return &httpInputWrapper{
+		name: unixSocketInputPrefix + socket + ":" + path,
+		url:  "http://localhost" + path,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+				// Wrappers are created for every merge
+				DisableKeepAlives: true,
+			},
+		},
+		timeout: timeout,
+	}
+}
+
+func (w *httpInputWrapper) Name() string {
+	if w.name != "" {
+		return w.name
+	}
+
+	return w.url
+}
+
+func (w *httpInputWrapper) Process(ctx context.Context, fn func(io.Reader) error) error {
+	timeout := w.timeout
+
+	if timeout <= 0 {
+		timeout = defaultHTTPInputTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, w.url, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "text/plain;version=0.0.4")
+
+	client := w.client
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", w.Name(), err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return fmt.Errorf("%s: unexpected status %q", w.Name(), resp.Status)
+	}
+
+	return processAndClose(ctx, w.Name(), resp.Body, fn)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHTTPInputWrapper(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "up 1\n")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		want    string
+		wantErr *regexp.Regexp
+	}{
+		{
+			name: "success",
+			path: "/metrics",
+			want: "up 1\n",
+		},
+		{
+			name:    "not found",
+			path:    "/missing",
+			wantErr: regexp.MustCompile(`/missing: unexpected status "404 Not Found"$`),
+		},
+		{
+			name:    "timeout",
+			path:    "/slow",
+			wantErr: regexp.MustCompile(`context deadline exceeded`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := &httpInputWrapper{
+				url:     server.URL + tc.path,
+				client:  server.Client(),
+				timeout: 100 * time.Millisecond,
+			}
+
+			var got string
+
+			err := w.Process(context.Background(), func(r io.Reader) error {
+				content, err := io.ReadAll(r)
+				got = string(content)
+				return err
+			})
+
+			if tc.wantErr != nil {
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Errorf("Process() failed with %v, want match for %q", err, tc.wantErr.String())
+				}
+			} else if err != nil {
+				t.Errorf("Process() failed: %v", err)
+			} else if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("Process() content difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUnixSocketInput(t *testing.T) {
+	for _, tc := range []struct {
+		input      string
+		wantSocket string
+		wantPath   string
+		wantOk     bool
+	}{
+		{input: "file.prom"},
+		{input: "./unix:file.prom"},
+		{input: "unix:/run/app.sock", wantSocket: "/run/app.sock", wantPath: "/metrics", wantOk: true},
+		{input: "unix:/run/app.sock:/", wantSocket: "/run/app.sock", wantPath: "/", wantOk: true},
+		{input: "unix:app.sock:/debug/metrics", wantSocket: "app.sock", wantPath: "/debug/metrics", wantOk: true},
+	} {
+		t.Run(tc.input, func(t *testing.T) {
+			socket, path, ok := unixSocketInput(tc.input)
+
+			if socket != tc.wantSocket || path != tc.wantPath || ok != tc.wantOk {
+				t.Errorf("unixSocketInput(%q) returned (%q, %q, %v), want (%q, %q, %v)",
+					tc.input, socket, path, ok, tc.wantSocket, tc.wantPath, tc.wantOk)
+			}
+		})
+	}
+}
+
+func TestUnixSocketInputWrapper(t *testing.T) {
+	// Socket paths are limited in length
+	tmpdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(tmpdir)
+	})
+
+	socket := filepath.Join(tmpdir, "test.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("Listening on Unix domain socket failed: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "up 1\n")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+
+	server := &http.Server{Handler: mux}
+
+	go server.Serve(listener)
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	for _, tc := range []struct {
+		name    string
+		input   string
+		timeout time.Duration
+		want    string
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:  "default path",
+			input: "unix:" + socket,
+			want:  "up 1\n",
+		},
+		{
+			name:    "not found",
+			input:   "unix:" + socket + ":/missing",
+			wantErr: regexp.MustCompile(`^unix:.*test\.sock:/missing: unexpected status "404 Not Found"$`),
+		},
+		{
+			name:    "timeout",
+			input:   "unix:" + socket + ":/slow",
+			timeout: 10 * time.Millisecond,
+			wantErr: regexp.MustCompile(`context deadline exceeded`),
+		},
+		{
+			name:    "missing socket",
+			input:   "unix:" + filepath.Join(tmpdir, "missing.sock"),
+			wantErr: regexp.MustCompile(`missing\.sock`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			wrappers := inputWrappersFromArgs([]string{tc.input}, inputOptions{socketTimeout: tc.timeout})
+
+			if len(wrappers) != 1 {
+				t.Fatalf("inputWrappersFromArgs() returned %d inputs", len(wrappers))
+			}
+
+			var got string
+
+			err := wrappers[0].Process(context.Background(), func(r io.Reader) error {
+				content, err := io.ReadAll(r)
+				got = string(content)
+				return err
+			})
+
+			if tc.wantErr != nil {
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Errorf("Process() failed with %v, want match for %q", err, tc.wantErr.String())
+				}
+			} else if err != nil {
+				t.Errorf("Process() failed: %v", err)
+			} else if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("Process() content difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
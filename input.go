@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
@@ -20,15 +23,30 @@ var stdinReader io.ReadCloser = os.Stdin
 
 type inputWrapper interface {
 	Name() string
-	Process(func(io.Reader) error) error
+
+	// Process invokes the function with a reader for the input. Reading is
+	// aborted when the context is cancelled.
+	Process(context.Context, func(io.Reader) error) error
 }
 
 // processAndClose invokes the given function, passing the reader as an
-// argument, and always closes the reader before returning.
-func processAndClose(name string, r io.ReadCloser, fn func(io.Reader) error) error {
+// argument, and always closes the reader before returning. The reader is
+// closed early when the context is cancelled to interrupt blocked reads, e.g.
+// on a named pipe.
+func processAndClose(ctx context.Context, name string, r io.ReadCloser, fn func(io.Reader) error) error {
 	defer r.Close()
 
+	stop := context.AfterFunc(ctx, func() {
+		r.Close()
+	})
+	defer stop()
+
 	if err := fn(r); err != nil {
+		if ctx.Err() != nil {
+			// Report the cancellation instead of the failed read
+			err = ctx.Err()
+		}
+
 		return fmt.Errorf("%s: %w", name, err)
 	}
 
@@ -61,12 +79,38 @@ func (w *readerInputWrapper) Name() string {
 	return w.name
 }
 
-func (w *readerInputWrapper) Process(fn func(io.Reader) error) error {
-	return processAndClose(w.name, w.r, fn)
+func (w *readerInputWrapper) Process(ctx context.Context, fn func(io.Reader) error) error {
+	return processAndClose(ctx, w.name, w.r, fn)
+}
+
+// inputOptions controls how inputs backed by files, commands or sockets are
+// read.
+type inputOptions struct {
+	// Files not modified within the given duration are skipped. Zero disables
+	// the check.
+	maxAge time.Duration
+
+	// Acquire a shared advisory lock on files while reading them, waiting at
+	// most lockTimeout (zero waits until the merge is cancelled).
+	lock        bool
+	lockTimeout time.Duration
+
+	// Maximum run time of commands, zero for the default.
+	commandTimeout time.Duration
+
+	// Maximum duration of requests to Unix domain sockets, zero for the
+	// default.
+	socketTimeout time.Duration
+}
+
+// isStale reports whether the file was last modified longer than maxAge ago.
+func (o inputOptions) isStale(fi os.FileInfo) bool {
+	return o.maxAge > 0 && time.Since(fi.ModTime()) > o.maxAge
 }
 
 type fileInputWrapper struct {
 	path string
+	opts inputOptions
 }
 
 var _ inputWrapper = (*fileInputWrapper)(nil)
@@ -75,16 +119,99 @@ func (w *fileInputWrapper) Name() string {
 	return w.path
 }
 
-func (w *fileInputWrapper) Process(fn func(io.Reader) error) error {
-	r, err := os.Open(w.path)
+// openContext opens a file for reading. Opening a named pipe blocks until a
+// writer appears, so waiting is abandoned when the context is cancelled.
+func openContext(ctx context.Context, path string) (*os.File, error) {
+	type result struct {
+		fh  *os.File
+		err error
+	}
+
+	ch := make(chan result, 1)
+
+	go func() {
+		fh, err := os.Open(path)
+		ch <- result{fh, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.fh, r.err
+
+	case <-ctx.Done():
+		go func() {
+			if r := <-ch; r.fh != nil {
+				r.fh.Close()
+			}
+		}()
+
+		return nil, fmt.Errorf("%s: %w", path, ctx.Err())
+	}
+}
+
+// staleInputError is returned for files not modified within the maximum age.
+// Such inputs are excluded from the result while their modification time is
+// still reported.
+type staleInputError struct {
+	path    string
+	modTime time.Time
+}
+
+func (e *staleInputError) Error() string {
+	return fmt.Sprintf("%s: stale input (last modified %s)", e.path, e.modTime.Format(time.RFC3339))
+}
+
+func (w *fileInputWrapper) Process(ctx context.Context, fn func(io.Reader) error) error {
+	r, err := openContext(ctx, w.path)
 	if err != nil {
 		return err
 	}
 
-	return processAndClose(r.Name(), r, fn)
+	return processAndClose(ctx, r.Name(), r, func(in io.Reader) error {
+		if w.opts.lock {
+			lockCtx, cancel := withLockTimeout(ctx, w.opts.lockTimeout)
+			defer cancel()
+
+			if err := flockFile(lockCtx, r, false); err != nil {
+				return err
+			}
+		}
+
+		if w.opts.maxAge > 0 {
+			fi, err := r.Stat()
+			if err != nil {
+				return err
+			}
+
+			if w.opts.isStale(fi) {
+				return &staleInputError{path: w.path, modTime: fi.ModTime()}
+			}
+		}
+
+		return fn(in)
+	})
 }
 
-func inputWrappersFromPaths(paths []string) []inputWrapper {
+// isFileInput reports whether an input given as a command line argument
+// refers to a file, as opposed to standard input, a command or a Unix domain
+// socket.
+func isFileInput(arg string) bool {
+	if arg == stdinPlaceholder {
+		return false
+	}
+
+	if _, ok := commandInputArgs(arg); ok {
+		return false
+	}
+
+	_, _, ok := unixSocketInput(arg)
+
+	return !ok
+}
+
+// inputWrappersFromPaths returns inputs reading the given files. The paths are
+// never interpreted as commands or sockets.
+func inputWrappersFromPaths(paths []string, opts inputOptions) []inputWrapper {
 	var result []inputWrapper
 
 	for _, i := range paths {
@@ -93,7 +220,7 @@ func inputWrappersFromPaths(paths []string) []inputWrapper {
 		if i == stdinPlaceholder {
 			r = newReaderInputWrapper(stdinReader)
 		} else {
-			r = &fileInputWrapper{path: i}
+			r = &fileInputWrapper{path: i, opts: opts}
 		}
 
 		result = append(result, r)
@@ -102,7 +229,26 @@ func inputWrappersFromPaths(paths []string) []inputWrapper {
 	return result
 }
 
-func inputWrappersFromDirs(paths []string, pattern string) ([]inputWrapper, error) {
+// inputWrappersFromArgs returns inputs for command line arguments. Unlike paths
+// from configuration files or directories arguments may refer to commands
+// ("exec:") and Unix domain sockets ("unix:").
+func inputWrappersFromArgs(args []string, opts inputOptions) []inputWrapper {
+	var result []inputWrapper
+
+	for _, i := range args {
+		if cmd, ok := commandInputArgs(i); ok {
+			result = append(result, &commandInputWrapper{args: cmd, timeout: opts.commandTimeout})
+		} else if socket, path, ok := unixSocketInput(i); ok {
+			result = append(result, newUnixSocketInputWrapper(socket, path, opts.socketTimeout))
+		} else {
+			result = append(result, inputWrappersFromPaths([]string{i}, opts)...)
+		}
+	}
+
+	return result
+}
+
+func inputWrappersFromDirs(paths []string, pattern string, opts inputOptions) ([]inputWrapper, error) {
 	var result []inputWrapper
 
 	for _, path := range paths {
@@ -121,43 +267,133 @@ func inputWrappersFromDirs(paths []string, pattern string) ([]inputWrapper, erro
 				return nil, err
 			}
 
-			if matched {
-				result = append(result, &fileInputWrapper{
-					path: filepath.Join(path, i.Name()),
-				})
+			if !matched {
+				continue
 			}
+
+			// Stale files are detected when reading them, the same as files
+			// given as paths
+
+			result = append(result, &fileInputWrapper{
+				path: filepath.Join(path, i.Name()),
+				opts: opts,
+			})
 		}
 	}
 
 	return result, nil
 }
 
-func readMetricFamilies(w inputWrapper) (parsedInput, error) {
+// countingReader counts the number of bytes read and fails once they exceed
+// the budget.
+type countingReader struct {
+	r      io.Reader
+	n      int64
+	name   string
+	budget *inputBudget
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+
+	if n > 0 {
+		if budgetErr := r.budget.check(r.name, r.n); budgetErr != nil {
+			return n, budgetErr
+		}
+	}
+
+	return n, err
+}
+
+// readMetricFamilies reads and parses the input. Reading is aborted when the
+// input exceeds the budget, if any.
+func readMetricFamilies(ctx context.Context, w inputWrapper, budget *inputBudget) (parsedInput, error) {
 	var families map[string]*dto.MetricFamily
+	var modTime time.Time
+	var recorder *lineRecorder
+	var counter *countingReader
+
+	if err := w.Process(ctx, func(r io.Reader) error {
+		if st, ok := r.(interface{ Stat() (os.FileInfo, error) }); ok {
+			if fi, err := st.Stat(); err == nil && fi.Mode().IsRegular() {
+				modTime = fi.ModTime()
+			}
+		}
+
+		counter = &countingReader{r: r, name: w.Name(), budget: budget}
+		recorder = newLineRecorder(counter)
 
-	if err := w.Process(func(r io.Reader) error {
 		var err error
 		parser := expfmt.NewTextParser(model.UTF8Validation)
-		families, err = parser.TextToMetricFamilies(r)
+		families, err = parser.TextToMetricFamilies(recorder)
 		return err
 	}); err != nil {
-		return parsedInput{}, err
+		var pe expfmt.ParseError
+		var limitErr *limitError
+		var staleErr *staleInputError
+
+		if errors.As(err, &staleErr) {
+			log.Printf("%s: skipping stale input (last modified %s)", w.Name(), staleErr.modTime.Format(time.RFC3339))
+
+			return parsedInput{
+				name:    w.Name(),
+				modTime: staleErr.modTime,
+				stale:   true,
+			}, nil
+		}
+
+		if errors.As(err, &limitErr) {
+			return parsedInput{}, limitErr
+		}
+
+		if recorder != nil && errors.As(err, &pe) {
+			// Replace the error to include the offending line
+			err = recorder.parseError(w.Name(), pe)
+		}
+
+		return parsedInput{}, &inputError{name: w.Name(), err: err}
 	}
 
-	return parsedInput{
+	result := parsedInput{
 		name:     w.Name(),
+		modTime:  modTime,
 		families: families,
-	}, nil
+	}
+
+	if counter != nil {
+		result.size = counter.n
+	}
+
+	return result, nil
 }
 
 type parsedInput struct {
-	name     string
+	name string
+
+	// Reason for the input being excluded from the result. Only set when
+	// failed inputs are tolerated.
+	err error
+
+	// Modification time of regular files, zero for other inputs.
+	modTime time.Time
+
+	// The file wasn't modified within the maximum age and is excluded from
+	// the result.
+	stale bool
+
+	// Number of bytes read.
+	size int64
+
 	families map[string]*dto.MetricFamily
 }
 
 // readInputs parses all inputs, up to GOMAXPROCS concurrently, before sending
 // the resulting metric families to the given channel. Input order is preserved.
-func readInputs(ctx context.Context, inputs []inputWrapper, parsedCh chan<- parsedInput) error {
+// With keepGoing set inputs failing to be read or parsed are sent with their
+// error instead of aborting. Inputs exceeding the budget are always sent with
+// their error, leaving the limit policy to the merger.
+func readInputs(ctx context.Context, inputs []inputWrapper, keepGoing bool, budget *inputBudget, parsedCh chan<- parsedInput) error {
 	g, ctx := errgroup.WithContext(ctx)
 
 	// Limit number of outstanding readers. The outer channel is used to
@@ -181,9 +417,18 @@ func readInputs(ctx context.Context, inputs []inputWrapper, parsedCh chan<- pars
 			g.Go(func() error {
 				defer close(r)
 
-				p, err := readMetricFamilies(w)
+				p, err := readMetricFamilies(ctx, w, budget)
 				if err != nil {
-					return err
+					var limitErr *limitError
+
+					if !(keepGoing || errors.As(err, &limitErr)) {
+						return err
+					}
+
+					p = parsedInput{
+						name: w.Name(),
+						err:  err,
+					}
 				}
 
 				select {
@@ -202,7 +447,11 @@ func readInputs(ctx context.Context, inputs []inputWrapper, parsedCh chan<- pars
 	g.Go(func() error {
 		for r := range readers {
 			for p := range r {
-				parsedCh <- p
+				select {
+				case parsedCh <- p:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
 			}
 		}
 
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -117,8 +119,8 @@ func TestInputWrappersFromPaths(t *testing.T) {
 
 			var got []string
 
-			for _, i := range inputWrappersFromPaths(tc.paths) {
-				if err := i.Process(func(r io.Reader) error {
+			for _, i := range inputWrappersFromPaths(tc.paths, inputOptions{}) {
+				if err := i.Process(context.Background(), func(r io.Reader) error {
 					content, err := io.ReadAll(r)
 					if err != nil {
 						return err
@@ -174,7 +176,7 @@ func TestInputWrappersFromDirs(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			var got []string
 
-			inputs, err := inputWrappersFromDirs(tc.paths, tc.pattern)
+			inputs, err := inputWrappersFromDirs(tc.paths, tc.pattern, inputOptions{})
 			if err != nil {
 				t.Errorf("inputWrappersFromDirs() failed: %v", err)
 			}
@@ -192,6 +194,58 @@ func TestInputWrappersFromDirs(t *testing.T) {
 	}
 }
 
+func TestInputWrappersMaxAge(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	fresh := filepath.Join(tmpdir, "fresh.prom")
+	stale := filepath.Join(tmpdir, "stale.prom")
+
+	for _, path := range []string{fresh, stale} {
+		if err := os.WriteFile(path, []byte("up 1\n"), 0o644); err != nil {
+			t.Error(err)
+		}
+	}
+
+	old := time.Now().Add(-48 * time.Hour)
+
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Errorf("Chtimes() failed: %v", err)
+	}
+
+	opts := inputOptions{maxAge: time.Hour}
+
+	dirInputs, err := inputWrappersFromDirs([]string{tmpdir}, "*.prom", opts)
+	if err != nil {
+		t.Errorf("inputWrappersFromDirs() failed: %v", err)
+	}
+
+	for name, inputs := range map[string][]inputWrapper{
+		"dirs":  dirInputs,
+		"paths": inputWrappersFromPaths([]string{fresh, stale}, opts),
+	} {
+		t.Run(name, func(t *testing.T) {
+			got := map[string]bool{}
+
+			for _, i := range inputs {
+				p, err := readMetricFamilies(context.Background(), i, nil)
+				if err != nil {
+					t.Errorf("readMetricFamilies() failed: %v", err)
+				}
+
+				if p.stale && !p.modTime.Equal(old) {
+					t.Errorf("Stale input %q has modification time %v, want %v", p.name, p.modTime, old)
+				}
+
+				got[p.name] = p.stale
+			}
+
+			if diff := cmp.Diff(got, map[string]bool{fresh: false, stale: true}); diff != "" {
+				t.Errorf("stale input difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestReadMetricFamilies(t *testing.T) {
 	for _, tc := range []struct {
 		name    string
@@ -212,6 +266,7 @@ func TestReadMetricFamilies(t *testing.T) {
 				"size 444\n# HELP weight foo\n# TYPE weight gauge\nweight 111\n")),
 			want: parsedInput{
 				name: "metrics",
+				size: 58,
 				families: map[string]*dto.MetricFamily{
 					"size":   nil,
 					"weight": nil,
@@ -225,7 +280,7 @@ func TestReadMetricFamilies(t *testing.T) {
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			got, err := readMetricFamilies(tc.wrapper)
+			got, err := readMetricFamilies(context.Background(), tc.wrapper, nil)
 
 			if tc.wantErr != nil {
 				if err == nil || !tc.wantErr.MatchString(err.Error()) {
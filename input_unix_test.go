@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestFileInputWrapperNamedPipe(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "fifo")
+
+	if err := syscall.Mkfifo(path, 0o600); err != nil {
+		t.Fatalf("Mkfifo() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	t.Cleanup(cancel)
+
+	// Opening blocks as there is no writer
+	if _, err := readMetricFamilies(ctx, &fileInputWrapper{path: path}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("readMetricFamilies() failed with %v, want deadline exceeded", err)
+	}
+}
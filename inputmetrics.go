@@ -0,0 +1,92 @@
+package main
+
+import (
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const inputMetricsPrefix = "textformat_merge_input_"
+
+// inputStatus summarizes a single processed input for the generated input
+// metrics.
+type inputStatus struct {
+	name    string
+	modTime time.Time
+	series  int
+	success bool
+
+	// Excluded for not being modified within the maximum age.
+	stale bool
+}
+
+func newInputStatus(input parsedInput) inputStatus {
+	s := inputStatus{
+		name:    input.name,
+		modTime: input.modTime,
+		success: true,
+	}
+
+	for _, mf := range input.families {
+		s.series += len(mf.GetMetric())
+	}
+
+	return s
+}
+
+func newGaugeFamily(name, help string) *dto.MetricFamily {
+	return &dto.MetricFamily{
+		Name: proto.String(name),
+		Help: proto.String(help),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+}
+
+func appendInputGauge(mf *dto.MetricFamily, path string, value float64) {
+	mf.Metric = append(mf.Metric, &dto.Metric{
+		Label: []*dto.LabelPair{
+			{Name: proto.String("path"), Value: proto.String(path)},
+		},
+		Gauge: &dto.Gauge{Value: proto.Float64(value)},
+	})
+}
+
+// inputMetricFamilies generates families describing the given inputs. Each
+// series has a "path" label with the input name.
+func inputMetricFamilies(inputs []inputStatus) map[string]*dto.MetricFamily {
+	modTime := newGaugeFamily(inputMetricsPrefix+"last_modified_timestamp_seconds",
+		"Modification time of the input file in seconds since the Unix epoch.")
+	series := newGaugeFamily(inputMetricsPrefix+"series",
+		"Number of series read from the input.")
+	success := newGaugeFamily(inputMetricsPrefix+"parse_success",
+		"Whether the input was read and parsed successfully.")
+
+	for _, i := range inputs {
+		if !i.modTime.IsZero() {
+			appendInputGauge(modTime, i.name, float64(i.modTime.UnixNano())/1e9)
+		}
+
+		if i.stale {
+			continue
+		}
+
+		appendInputGauge(series, i.name, float64(i.series))
+
+		if i.success {
+			appendInputGauge(success, i.name, 1)
+		} else {
+			appendInputGauge(success, i.name, 0)
+		}
+	}
+
+	result := map[string]*dto.MetricFamily{}
+
+	for _, mf := range []*dto.MetricFamily{modTime, series, success} {
+		if len(mf.Metric) > 0 {
+			result[mf.GetName()] = mf
+		}
+	}
+
+	return result
+}
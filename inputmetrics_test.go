@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInputMetricFamilies(t *testing.T) {
+	pathLabel := func(value string) []*dto.LabelPair {
+		return []*dto.LabelPair{{Name: newString("path"), Value: newString(value)}}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		inputs []inputStatus
+		want   map[string]*dto.MetricFamily
+	}{
+		{name: "empty"},
+		{
+			name: "mixed",
+			inputs: []inputStatus{
+				{name: "a.prom", modTime: time.Unix(1700000000, 500e6), series: 3, success: true},
+				{name: "stdin", series: 1, success: true},
+				{name: "bad.prom", success: false},
+				{name: "stale.prom", modTime: time.Unix(1600000000, 0), stale: true},
+			},
+			want: map[string]*dto.MetricFamily{
+				"textformat_merge_input_last_modified_timestamp_seconds": {
+					Name: newString("textformat_merge_input_last_modified_timestamp_seconds"),
+					Help: newString("Modification time of the input file in seconds since the Unix epoch."),
+					Type: dto.MetricType_GAUGE.Enum(),
+					Metric: []*dto.Metric{
+						{Label: pathLabel("a.prom"), Gauge: &dto.Gauge{Value: newFloat64(1700000000.5)}},
+						{Label: pathLabel("stale.prom"), Gauge: &dto.Gauge{Value: newFloat64(1600000000)}},
+					},
+				},
+				"textformat_merge_input_series": {
+					Name: newString("textformat_merge_input_series"),
+					Help: newString("Number of series read from the input."),
+					Type: dto.MetricType_GAUGE.Enum(),
+					Metric: []*dto.Metric{
+						{Label: pathLabel("a.prom"), Gauge: &dto.Gauge{Value: newFloat64(3)}},
+						{Label: pathLabel("stdin"), Gauge: &dto.Gauge{Value: newFloat64(1)}},
+						{Label: pathLabel("bad.prom"), Gauge: &dto.Gauge{Value: newFloat64(0)}},
+					},
+				},
+				"textformat_merge_input_parse_success": {
+					Name: newString("textformat_merge_input_parse_success"),
+					Help: newString("Whether the input was read and parsed successfully."),
+					Type: dto.MetricType_GAUGE.Enum(),
+					Metric: []*dto.Metric{
+						{Label: pathLabel("a.prom"), Gauge: &dto.Gauge{Value: newFloat64(1)}},
+						{Label: pathLabel("stdin"), Gauge: &dto.Gauge{Value: newFloat64(1)}},
+						{Label: pathLabel("bad.prom"), Gauge: &dto.Gauge{Value: newFloat64(0)}},
+					},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := inputMetricFamilies(tc.inputs)
+
+			if diff := cmp.Diff(got, tc.want, protocmp.Transform(), cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("inputMetricFamilies() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// inputSource describes where a merge job reads its inputs from. Exactly one
+// of files, args, dir, url, socket and command is set.
+type inputSource struct {
+	files []string
+
+	// Command line arguments, each a file, standard input, a command or a
+	// Unix domain socket.
+	args []string
+
+	// Directory whose entries matching the pattern are read.
+	dir     string
+	pattern string
+
+	// URL fetched via HTTP, Unix domain socket serving HTTP or program and
+	// arguments to run, all at most for the given timeout.
+	url         string
+	socket      string
+	metricsPath string
+	command     []string
+	timeout     time.Duration
+
+	opts inputOptions
+}
+
+func (s inputSource) inputs() ([]inputWrapper, error) {
+	switch {
+	case s.dir != "":
+		return inputWrappersFromDirs([]string{s.dir}, s.pattern, s.opts)
+
+	case s.url != "":
+		return []inputWrapper{&httpInputWrapper{
+			url:     s.url,
+			timeout: s.timeout,
+		}}, nil
+
+	case s.socket != "":
+		return []inputWrapper{newUnixSocketInputWrapper(s.socket, s.metricsPath, s.timeout)}, nil
+
+	case len(s.command) > 0:
+		return []inputWrapper{&commandInputWrapper{
+			args:    s.command,
+			timeout: s.timeout,
+		}}, nil
+
+	case len(s.args) > 0:
+		return inputWrappersFromArgs(s.args, s.opts), nil
+	}
+
+	return inputWrappersFromPaths(s.files, s.opts), nil
+}
+
+// watch registers the files and directories of the source. URLs, sockets and
+// commands can't be watched.
+func (s inputSource) watch(spec *watchSpec) {
+	switch {
+	case s.dir != "":
+		spec.addPattern(s.dir, s.pattern)
+
+	case s.url != "", s.socket != "", len(s.command) > 0:
+
+	case len(s.args) > 0:
+		for _, i := range s.args {
+			if isFileInput(i) {
+				spec.addFile(i)
+			}
+		}
+
+	default:
+		for _, i := range s.files {
+			spec.addFile(i)
+		}
+	}
+}
+
+// mergeJob reads and merges a set of inputs and writes the result to one or
+// more outputs.
+type mergeJob struct {
+	name       string
+	sources    []inputSource
+	opts       mergeOptions
+	outputs    []outputTarget
+	showInputs bool
+
+	// Serialize runs using advisory locks on the output files.
+	lock        bool
+	lockTimeout time.Duration
+
+	// Maximum duration of a single run, zero disables the timeout.
+	timeout time.Duration
+
+	// Configuration the job was created from, used to report changes on
+	// reload.
+	config string
+}
+
+func (j *mergeJob) inputs() ([]inputWrapper, error) {
+	var result []inputWrapper
+
+	for _, s := range j.sources {
+		inputs, err := s.inputs()
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, inputs...)
+	}
+
+	return result, nil
+}
+
+// outputPaths returns the sorted paths of all file outputs.
+func (j *mergeJob) outputPaths() []string {
+	var result []string
+
+	seen := map[string]struct{}{}
+
+	for _, i := range j.outputs {
+		if i.path == "" {
+			continue
+		}
+
+		if _, ok := seen[i.path]; !ok {
+			seen[i.path] = struct{}{}
+			result = append(result, i.path)
+		}
+	}
+
+	sort.Strings(result)
+
+	return result
+}
+
+// writesStdout reports whether standard output is one of the outputs.
+func (j *mergeJob) writesStdout() bool {
+	for _, i := range j.outputs {
+		if i.path == "" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readsStdin reports whether standard input is one of the inputs.
+func (j *mergeJob) readsStdin() bool {
+	for _, s := range j.sources {
+		if slices.Contains(s.files, stdinPlaceholder) || slices.Contains(s.args, stdinPlaceholder) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// run reads and merges all inputs before writing the result to the outputs.
+func (j *mergeJob) run(ctx context.Context) error {
+	if j.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, j.timeout)
+		defer cancel()
+	}
+
+	if j.lock {
+		// Paths are sorted to avoid deadlocks
+		for _, path := range j.outputPaths() {
+			unlock, err := lockOutput(ctx, path, j.lockTimeout)
+			if err != nil {
+				return err
+			}
+
+			defer unlock()
+		}
+	}
+
+	inputs, err := j.inputs()
+	if err != nil {
+		return err
+	}
+
+	merged, err := readAndMerge(ctx, inputs, j.opts)
+	if err != nil {
+		return err
+	}
+
+	defer merged.close()
+
+	for _, err := range merged.failures {
+		log.Printf("Skipped input: %v", err)
+	}
+
+	for _, target := range j.outputs {
+		if err := withOutput(target.path, target.opts, func(w io.Writer) error {
+			return target.write(w, merged, j.showInputs)
+		}); err != nil {
+			return &outputError{err}
+		}
+	}
+
+	if len(merged.failures) > 0 {
+		return &partialFailureError{failures: merged.failures}
+	}
+
+	return nil
+}
+
+// handler returns an HTTP handler merging the inputs on every request.
+func (j *mergeJob) handler() *mergeHandler {
+	return &mergeHandler{
+		inputs:     j.inputs,
+		opts:       j.opts,
+		showInputs: j.showInputs,
+		timeout:    j.timeout,
+	}
+}
+
+// runJobs runs all jobs one after another. A failing job doesn't prevent the
+// remaining jobs from running. Errors other than partial failures are
+// reported first as they determine the exit code.
+func runJobs(ctx context.Context, jobs []*mergeJob) error {
+	if len(jobs) == 1 {
+		return jobs[0].run(ctx)
+	}
+
+	var fatal, partial []error
+
+	for _, j := range jobs {
+		err := j.run(ctx)
+		if err == nil {
+			continue
+		}
+
+		err = fmt.Errorf("job %q: %w", j.name, err)
+
+		var partialErr *partialFailureError
+
+		if errors.As(err, &partialErr) {
+			partial = append(partial, err)
+		} else {
+			fatal = append(fatal, err)
+		}
+	}
+
+	return errors.Join(append(fatal, partial...)...)
+}
+
+// jobsHandler serves the merged metrics of each job below a common prefix,
+// e.g. "/metrics/<job>".
+type jobsHandler struct {
+	prefix string
+	jobs   map[string]*mergeHandler
+}
+
+var _ http.Handler = (*jobsHandler)(nil)
+
+func newJobsHandler(prefix string, jobs []*mergeJob) *jobsHandler {
+	h := &jobsHandler{
+		prefix: strings.TrimSuffix(prefix, "/") + "/",
+		jobs:   map[string]*mergeHandler{},
+	}
+
+	for _, j := range jobs {
+		h.jobs[j.name] = j.handler()
+	}
+
+	return h
+}
+
+func (h *jobsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name, ok := strings.CutPrefix(r.URL.Path, h.prefix)
+
+	if handler := h.jobs[name]; ok && handler != nil {
+		handler.ServeHTTP(w, r)
+		return
+	}
+
+	http.NotFound(w, r)
+}
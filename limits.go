@@ -0,0 +1,187 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// limitPolicy determines how limit violations are handled.
+type limitPolicy string
+
+const (
+	// Abort the merge.
+	limitPolicyFail limitPolicy = "fail"
+
+	// Drop the series exceeding a limit. The input byte limit can't be
+	// enforced by truncation and drops the whole input.
+	limitPolicyTruncate limitPolicy = "truncate"
+
+	// Exclude the whole input from the result.
+	limitPolicyDrop limitPolicy = "drop"
+)
+
+var _ flag.Value = (*limitPolicy)(nil)
+
+func (p *limitPolicy) String() string {
+	return string(*p)
+}
+
+func (p *limitPolicy) Set(value string) error {
+	switch v := limitPolicy(value); v {
+	case limitPolicyFail, limitPolicyTruncate, limitPolicyDrop:
+		*p = v
+		return nil
+	}
+
+	return fmt.Errorf("unknown limit policy %q", value)
+}
+
+// mergeLimits restricts the size of the merged result. Zero values disable
+// the individual limits.
+type mergeLimits struct {
+	series           int
+	seriesPerFamily  int
+	labelsPerSeries  int
+	labelValueLength int
+	inputBytes       int64
+
+	// Defaults to failing.
+	policy limitPolicy
+}
+
+// enabled reports whether any limit is set.
+func (l mergeLimits) enabled() bool {
+	return l.series > 0 || l.seriesPerFamily > 0 || l.labelsPerSeries > 0 ||
+		l.labelValueLength > 0 || l.inputBytes > 0
+}
+
+// inputBudget enforces the input byte limit while inputs are read. Readers
+// fail as soon as the bytes of already merged inputs plus their own exceed the
+// limit, before the input is read completely.
+type inputBudget struct {
+	limit int64
+
+	// Bytes of the inputs merged so far.
+	merged atomic.Int64
+}
+
+func newInputBudget(limits mergeLimits) *inputBudget {
+	if limits.inputBytes <= 0 {
+		return nil
+	}
+
+	return &inputBudget{limit: limits.inputBytes}
+}
+
+// check returns a limitError if reading n bytes of the input exceeds the
+// remaining budget. A nil budget is unlimited.
+func (b *inputBudget) check(name string, n int64) error {
+	if b == nil {
+		return nil
+	}
+
+	if total := b.merged.Load() + n; total > b.limit {
+		return &limitError{
+			input: name,
+			err:   fmt.Errorf("reading %d bytes exceeds limit of %d bytes", total, b.limit),
+		}
+	}
+
+	return nil
+}
+
+// add records the size of a merged input.
+func (b *inputBudget) add(n int64) {
+	if b != nil {
+		b.merged.Add(n)
+	}
+}
+
+// checkLimits verifies whether adding the input stays within the limits. With
+// the truncate policy series exceeding a limit are removed from the input
+// families and families left without series are removed from the input. The
+// returned error is always a limitError.
+func (m *metricsMerger) checkLimits(input parsedInput) error {
+	l := m.opts.limits
+
+	if !l.enabled() {
+		return nil
+	}
+
+	if l.inputBytes > 0 && m.stats.bytesRead+input.size > l.inputBytes {
+		return &limitError{
+			input: input.name,
+			err:   fmt.Errorf("reading %d bytes exceeds limit of %d bytes", m.stats.bytesRead+input.size, l.inputBytes),
+		}
+	}
+
+	// Series added by the input so far
+	var added int
+
+	for _, mf := range sortedFamilies(input.families) {
+		existing := m.familySeries[mf.GetName()]
+		kept := mf.Metric[:0:0]
+
+		var dropped int
+		var firstErr error
+
+		for _, metric := range mf.GetMetric() {
+			var err error
+
+			switch {
+			case l.series > 0 && m.series+added >= l.series:
+				err = fmt.Errorf("more than %d series", l.series)
+
+			case l.seriesPerFamily > 0 && existing+len(kept) >= l.seriesPerFamily:
+				err = fmt.Errorf("more than %d series in family", l.seriesPerFamily)
+
+			case l.labelsPerSeries > 0 && len(metric.GetLabel()) > l.labelsPerSeries:
+				err = fmt.Errorf("%s: more than %d labels", formatSeries(mf.GetName(), metric.GetLabel()), l.labelsPerSeries)
+
+			default:
+				if l.labelValueLength > 0 {
+					for _, lp := range metric.GetLabel() {
+						if len(lp.GetValue()) > l.labelValueLength {
+							err = fmt.Errorf("%s: value of label %q longer than %d bytes",
+								formatSeries(mf.GetName(), metric.GetLabel()), lp.GetName(), l.labelValueLength)
+							break
+						}
+					}
+				}
+			}
+
+			if err == nil {
+				kept = append(kept, metric)
+				added++
+				continue
+			}
+
+			if l.policy != limitPolicyTruncate {
+				return &limitError{input: input.name, family: mf.GetName(), err: err}
+			}
+
+			if firstErr == nil {
+				firstErr = err
+			}
+
+			dropped++
+		}
+
+		if dropped > 0 {
+			log.Printf("%s: dropped %d series of family %q exceeding limits (%v)", input.name, dropped, mf.GetName(), firstErr)
+
+			m.stats.truncatedSeries += dropped
+
+			if len(kept) == 0 {
+				// Families without metrics can't be written
+				delete(input.families, mf.GetName())
+			} else {
+				mf.Metric = kept
+			}
+		}
+	}
+
+	return nil
+}
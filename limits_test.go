@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeLimits(t *testing.T) {
+	inputs := func() []inputWrapper {
+		return []inputWrapper{
+			newReaderInputWrapper(newFakeReaderWithName("a.txt",
+				"up{job=\"a\"} 1\nup{job=\"b\"} 1\nload 1\n")),
+			newReaderInputWrapper(newFakeReaderWithName("b.txt",
+				"up{job=\"c\",instance=\"long-instance-name\"} 1\nup{job=\"d\"} 1\n")),
+		}
+	}
+
+	for _, tc := range []struct {
+		name         string
+		limits       mergeLimits
+		want         map[string]int
+		wantFailures int
+		wantErr      *regexp.Regexp
+	}{
+		{
+			name: "unlimited",
+			want: map[string]int{"load": 1, "up": 4},
+		},
+		{
+			name:    "series",
+			limits:  mergeLimits{series: 4},
+			wantErr: regexp.MustCompile(`^b\.txt: family "up": more than 4 series$`),
+		},
+		{
+			name:   "series truncate",
+			limits: mergeLimits{series: 4, policy: limitPolicyTruncate},
+			want:   map[string]int{"load": 1, "up": 3},
+		},
+		{
+			name:         "series drop",
+			limits:       mergeLimits{series: 4, policy: limitPolicyDrop},
+			want:         map[string]int{"load": 1, "up": 2},
+			wantFailures: 1,
+		},
+		{
+			name:   "series per family",
+			limits: mergeLimits{seriesPerFamily: 3, policy: limitPolicyTruncate},
+			want:   map[string]int{"load": 1, "up": 3},
+		},
+		{
+			name:    "labels per series",
+			limits:  mergeLimits{labelsPerSeries: 1, policy: limitPolicyFail},
+			wantErr: regexp.MustCompile(`^b\.txt: family "up": up{instance="long-instance-name",job="c"}: more than 1 labels$`),
+		},
+		{
+			name:   "label value length",
+			limits: mergeLimits{labelValueLength: 10, policy: limitPolicyTruncate},
+			want:   map[string]int{"load": 1, "up": 3},
+		},
+		{
+			name:         "input bytes truncate",
+			limits:       mergeLimits{inputBytes: 40, policy: limitPolicyTruncate},
+			want:         map[string]int{"load": 1, "up": 2},
+			wantFailures: 1,
+		},
+		{
+			name:    "input bytes",
+			limits:  mergeLimits{inputBytes: 10},
+			wantErr: regexp.MustCompile(`^a\.txt: reading 35 bytes exceeds limit of 10 bytes$`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readAndMerge(context.Background(), inputs(), mergeOptions{limits: tc.limits})
+
+			if tc.wantErr != nil {
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Fatalf("readAndMerge() failed with %v, want match for %q", err, tc.wantErr.String())
+				}
+
+				return
+			} else if err != nil {
+				t.Fatalf("readAndMerge() failed with %v", err)
+			}
+
+			series := map[string]int{}
+
+			for _, mf := range got.families {
+				series[mf.GetName()] = len(mf.GetMetric())
+			}
+
+			if diff := cmp.Diff(series, tc.want); diff != "" {
+				t.Errorf("Series difference (-got +want):\n%s", diff)
+			}
+
+			if len(got.failures) != tc.wantFailures {
+				t.Errorf("readAndMerge() reported failures %q, want %d", got.failures, tc.wantFailures)
+			}
+		})
+	}
+}
+
+func TestMergeLimitsTruncateFamily(t *testing.T) {
+	inputs := []inputWrapper{
+		newReaderInputWrapper(newFakeReaderWithName("a.txt",
+			"up 1\nwide{x=\"1\",y=\"2\",z=\"3\"} 1\n")),
+	}
+
+	got, err := readAndMerge(context.Background(), inputs, mergeOptions{
+		limits: mergeLimits{labelsPerSeries: 2, policy: limitPolicyTruncate},
+	})
+	if err != nil {
+		t.Fatalf("readAndMerge() failed: %v", err)
+	}
+
+	var buf strings.Builder
+
+	if err := got.write(&buf, false); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(buf.String(), "# TYPE up untyped\nup 1\n"); diff != "" {
+		t.Errorf("write() difference (-got +want):\n%s", diff)
+	}
+}
+
+// endlessReader returns the same line forever.
+type endlessReader struct{}
+
+func (endlessReader) Read(p []byte) (int, error) {
+	const line = "up 1\n"
+
+	n := 0
+
+	for n+len(line) <= len(p) {
+		n += copy(p[n:], line)
+	}
+
+	return n, nil
+}
+
+func TestMergeLimitsInputBytesWhileReading(t *testing.T) {
+	for _, tc := range []struct {
+		name         string
+		policy       limitPolicy
+		wantFailures int
+		wantErr      *regexp.Regexp
+	}{
+		{
+			name:    "fail",
+			policy:  limitPolicyFail,
+			wantErr: regexp.MustCompile(`^endless: reading \d+ bytes exceeds limit of 1024 bytes$`),
+		},
+		{
+			name:         "drop",
+			policy:       limitPolicyDrop,
+			wantFailures: 1,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			inputs := []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt", "load 1\n")),
+				&readerInputWrapper{name: "endless", r: io.NopCloser(endlessReader{})},
+			}
+
+			got, err := readAndMerge(context.Background(), inputs, mergeOptions{
+				limits: mergeLimits{inputBytes: 1024, policy: tc.policy},
+			})
+
+			if tc.wantErr != nil {
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Errorf("readAndMerge() failed with %v, want match for %q", err, tc.wantErr.String())
+				}
+
+				return
+			} else if err != nil {
+				t.Fatalf("readAndMerge() failed with %v", err)
+			}
+
+			if len(got.failures) != tc.wantFailures {
+				t.Errorf("readAndMerge() reported failures %q, want %d", got.failures, tc.wantFailures)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+type problemSeverity int
+
+const (
+	severityWarning problemSeverity = iota
+	severityError
+)
+
+func (s problemSeverity) String() string {
+	if s == severityError {
+		return "error"
+	}
+
+	return "warning"
+}
+
+// problem is an issue found by lintFamilies.
+type problem struct {
+	severity problemSeverity
+	family   string
+	message  string
+}
+
+func (p problem) String() string {
+	return fmt.Sprintf("%s: %s: %s", p.severity, p.family, p.message)
+}
+
+// Units which should be replaced by their base unit.
+var nonBaseUnits = map[string]string{
+	"nanoseconds":  "seconds",
+	"microseconds": "seconds",
+	"milliseconds": "seconds",
+	"minutes":      "seconds",
+	"hours":        "seconds",
+	"days":         "seconds",
+	"kilobytes":    "bytes",
+	"megabytes":    "bytes",
+	"gigabytes":    "bytes",
+	"terabytes":    "bytes",
+	"kibibytes":    "bytes",
+	"mebibytes":    "bytes",
+	"gibibytes":    "bytes",
+	"tebibytes":    "bytes",
+	"percent":      "ratio",
+	"fahrenheit":   "celsius",
+}
+
+type familyLinter struct {
+	mf       *dto.MetricFamily
+	problems []problem
+}
+
+func (l *familyLinter) report(severity problemSeverity, format string, args ...any) {
+	l.problems = append(l.problems, problem{
+		severity: severity,
+		family:   l.mf.GetName(),
+		message:  fmt.Sprintf(format, args...),
+	})
+}
+
+func (l *familyLinter) checkName() {
+	name := l.mf.GetName()
+
+	if !model.IsValidLegacyMetricName(name) {
+		l.report(severityWarning, "metric name is not supported by the legacy validation scheme")
+	}
+
+	if strings.TrimSpace(l.mf.GetHelp()) == "" {
+		l.report(severityWarning, "no help text")
+	}
+
+	if l.mf.GetType() == dto.MetricType_COUNTER && !strings.HasSuffix(name, "_total") {
+		l.report(severityWarning, `counter metrics should have "_total" suffix`)
+	}
+
+	for _, token := range strings.Split(name, "_") {
+		if base, ok := nonBaseUnits[token]; ok {
+			l.report(severityWarning, "use base unit %q instead of %q", base, token)
+		}
+	}
+}
+
+func (l *familyLinter) checkLabels(series string, m *dto.Metric) {
+	for _, lp := range m.GetLabel() {
+		name := lp.GetName()
+
+		if strings.HasPrefix(name, "__") {
+			l.report(severityError, "%s: label name %q is reserved", series, name)
+		} else if !model.LabelName(name).IsValidLegacy() {
+			l.report(severityWarning, "%s: label name %q is not supported by the legacy validation scheme", series, name)
+		}
+	}
+}
+
+func (l *familyLinter) checkHistogram(series string, h *dto.Histogram) {
+	var prevBound, prevCount float64
+
+	buckets := h.GetBucket()
+
+	for idx, b := range buckets {
+		bound := b.GetUpperBound()
+		count := bucketCount(b)
+
+		if math.IsNaN(bound) {
+			l.report(severityError, "%s: bucket with NaN upper bound", series)
+		} else if idx > 0 && bound <= prevBound {
+			l.report(severityError, "%s: bucket upper bounds not increasing (%s after %s)",
+				series, formatValue(bound), formatValue(prevBound))
+		}
+
+		if idx > 0 && count < prevCount {
+			l.report(severityError, "%s: cumulative bucket counts decreasing (%s after %s at le=%q)",
+				series, formatValue(count), formatValue(prevCount), formatValue(bound))
+		}
+
+		prevBound, prevCount = bound, count
+	}
+
+	if len(buckets) == 0 || !math.IsInf(prevBound, +1) {
+		l.report(severityError, "%s: no +Inf bucket", series)
+	} else if count := histogramCount(h); prevCount != count {
+		l.report(severityError, "%s: +Inf bucket count %s differs from sample count %s",
+			series, formatValue(prevCount), formatValue(count))
+	}
+}
+
+func (l *familyLinter) checkMetric(series string, m *dto.Metric) {
+	switch l.mf.GetType() {
+	case dto.MetricType_COUNTER:
+		if value := m.GetCounter().GetValue(); math.IsNaN(value) {
+			l.report(severityError, "%s: counter value is NaN", series)
+		} else if value < 0 {
+			l.report(severityError, "%s: counter value is negative", series)
+		}
+
+	case dto.MetricType_SUMMARY:
+		for _, q := range m.GetSummary().GetQuantile() {
+			if value := q.GetQuantile(); math.IsNaN(value) || value < 0 || value > 1 {
+				l.report(severityError, "%s: quantile %s out of range", series, formatValue(value))
+			}
+		}
+
+	case dto.MetricType_HISTOGRAM:
+		l.checkHistogram(series, m.GetHistogram())
+	}
+}
+
+// lintFamily checks a metric family for problems. Errors cause problems for
+// consumers such as Prometheus while warnings concern best practices.
+func lintFamily(mf *dto.MetricFamily) []problem {
+	l := familyLinter{mf: mf}
+
+	l.checkName()
+
+	seen := map[string]struct{}{}
+
+	for _, m := range mf.GetMetric() {
+		series := formatSeries(mf.GetName(), m.GetLabel())
+
+		if _, ok := seen[series]; ok {
+			l.report(severityError, "duplicate series %s", series)
+		} else {
+			seen[series] = struct{}{}
+		}
+
+		l.checkLabels(series, m)
+		l.checkMetric(series, m)
+	}
+
+	return l.problems
+}
+
+// lintFamilies checks all families in order.
+func lintFamilies(families []*dto.MetricFamily) []problem {
+	var result []problem
+
+	for _, mf := range families {
+		result = append(result, lintFamily(mf)...)
+	}
+
+	return result
+}
+
+// reportProblems logs all problems of error severity and returns an error if
+// there are any. Warnings are ignored.
+func reportProblems(input string, problems []problem) error {
+	var count int
+
+	for _, p := range problems {
+		if p.severity < severityError {
+			continue
+		}
+
+		log.Printf("%s: %s", input, p)
+		count++
+	}
+
+	if count > 0 {
+		return &validationError{input: input, problems: count}
+	}
+
+	return nil
+}
+
+// validateMerged checks all families of a merge result.
+func validateMerged(input string, merged *mergedInputs) error {
+	var problems []problem
+
+	if err := merged.each(func(mf *dto.MetricFamily) error {
+		problems = append(problems, lintFamily(mf)...)
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	return reportProblems(input, problems)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLintFamilies(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "good",
+			content: `# HELP requests_total Requests.
+# TYPE requests_total counter
+requests_total{code="200"} 10
+requests_total{code="500"} 1
+# HELP latency_seconds Latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="0.1"} 1
+latency_seconds_bucket{le="1"} 3
+latency_seconds_bucket{le="+Inf"} 4
+latency_seconds_sum 2
+latency_seconds_count 4
+# HELP rpc_seconds RPC duration.
+# TYPE rpc_seconds summary
+rpc_seconds{quantile="0.5"} 0.2
+rpc_seconds_sum 1
+rpc_seconds_count 3
+`,
+		},
+		{
+			name: "naming",
+			content: `# TYPE requests counter
+requests 1
+# HELP size_kilobytes Size.
+# TYPE size_kilobytes gauge
+size_kilobytes{__internal="x"} 1
+`,
+			want: []string{
+				`warning: requests: no help text`,
+				`warning: requests: counter metrics should have "_total" suffix`,
+				`warning: size_kilobytes: use base unit "bytes" instead of "kilobytes"`,
+				`error: size_kilobytes: size_kilobytes{__internal="x"}: label name "__internal" is reserved`,
+			},
+		},
+		{
+			name: "duplicate series",
+			content: `# HELP up Up.
+# TYPE up gauge
+up{job="a"} 1
+up{job="a"} 0
+`,
+			want: []string{
+				`error: up: duplicate series up{job="a"}`,
+			},
+		},
+		{
+			name: "counter values",
+			content: `# HELP errors_total Errors.
+# TYPE errors_total counter
+errors_total{a="1"} NaN
+errors_total{a="2"} -1
+`,
+			want: []string{
+				`error: errors_total: errors_total{a="1"}: counter value is NaN`,
+				`error: errors_total: errors_total{a="2"}: counter value is negative`,
+			},
+		},
+		{
+			name: "histogram",
+			content: `# HELP a_seconds A.
+# TYPE a_seconds histogram
+a_seconds_bucket{le="1"} 5
+a_seconds_bucket{le="0.5"} 3
+a_seconds_sum 1
+a_seconds_count 5
+# HELP b_seconds B.
+# TYPE b_seconds histogram
+b_seconds_bucket{le="1"} 5
+b_seconds_bucket{le="+Inf"} 6
+b_seconds_sum 1
+b_seconds_count 7
+`,
+			want: []string{
+				`error: a_seconds: a_seconds: bucket upper bounds not increasing (0.5 after 1)`,
+				`error: a_seconds: a_seconds: cumulative bucket counts decreasing (3 after 5 at le="0.5")`,
+				`error: a_seconds: a_seconds: no +Inf bucket`,
+				`error: b_seconds: b_seconds: +Inf bucket count 6 differs from sample count 7`,
+			},
+		},
+		{
+			name: "summary quantile",
+			content: `# HELP rpc_seconds RPC duration.
+# TYPE rpc_seconds summary
+rpc_seconds{quantile="1.5"} 0.2
+rpc_seconds_sum 1
+rpc_seconds_count 3
+`,
+			want: []string{
+				`error: rpc_seconds: rpc_seconds: quantile 1.5 out of range`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := mustParseInput(t, "input", tc.content)
+
+			var got []string
+
+			for _, i := range lintFamilies(sortedFamilies(p.families)) {
+				got = append(got, i.String())
+			}
+
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("lintFamilies() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
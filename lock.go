@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+const lockFileSuffix = ".lock"
+
+// withLockTimeout limits the time spent waiting for a lock. A zero timeout
+// waits until the context is cancelled.
+func withLockTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout > 0 {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// lockOutput acquires an exclusive lock on a file next to the output path. The
+// returned function releases the lock. Lock files are not removed as doing so
+// would race with other processes waiting for the lock.
+func lockOutput(ctx context.Context, path string, timeout time.Duration) (func(), error) {
+	fh, err := os.OpenFile(path+lockFileSuffix, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withLockTimeout(ctx, timeout)
+	defer cancel()
+
+	if err := flockFile(ctx, fh, true); err != nil {
+		fh.Close()
+		return nil, err
+	}
+
+	return func() {
+		fh.Close()
+	}, nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLockOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File locking is not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "output.prom")
+
+	unlock, err := lockOutput(context.Background(), path, 0)
+	if err != nil {
+		t.Fatalf("lockOutput() failed: %v", err)
+	}
+
+	if _, err := lockOutput(context.Background(), path, 10*time.Millisecond); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("lockOutput() on locked file failed with %v, want timeout", err)
+	}
+
+	unlock()
+
+	if unlock, err := lockOutput(context.Background(), path, 10*time.Millisecond); err != nil {
+		t.Errorf("lockOutput() after unlocking failed: %v", err)
+	} else {
+		unlock()
+	}
+
+	if _, err := os.Stat(path + lockFileSuffix); err != nil {
+		t.Errorf("Lock file missing: %v", err)
+	}
+}
+
+func TestLockInputs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File locking is not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "input.prom")
+
+	if err := os.WriteFile(path, []byte("up 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fh, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer fh.Close()
+
+	if err := flockFile(context.Background(), fh, true); err != nil {
+		t.Fatalf("flockFile() failed: %v", err)
+	}
+
+	opts := inputOptions{lock: true, lockTimeout: 10 * time.Millisecond}
+
+	if _, err := readMetricFamilies(context.Background(), &fileInputWrapper{path: path, opts: opts}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("readMetricFamilies() failed with %v, want timeout", err)
+	}
+
+	if _, err := readMetricFamilies(context.Background(), &fileInputWrapper{path: path}, nil); err != nil {
+		t.Errorf("readMetricFamilies() without locking failed: %v", err)
+	}
+
+	// Waiting without a lock timeout ends with the merge
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := readMetricFamilies(ctx, &fileInputWrapper{path: path, opts: inputOptions{lock: true}}, nil); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("readMetricFamilies() without lock timeout failed with %v, want merge timeout", err)
+	}
+
+	fh.Close()
+
+	if _, err := readMetricFamilies(context.Background(), &fileInputWrapper{path: path, opts: opts}, nil); err != nil {
+		t.Errorf("readMetricFamilies() after unlocking failed: %v", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math/rand/v2"
+	"time"
+)
+
+// runPeriodically invokes fn at the given interval until the context is
+// cancelled. A random delay of up to jitter is added to each wait. Failures of
+// fn are logged; partial failures still count as success.
+func runPeriodically(ctx context.Context, interval, jitter time.Duration, fn func(context.Context) error) error {
+	var lastSuccess time.Time
+
+	for {
+		start := time.Now()
+
+		err := fn(ctx)
+
+		switch {
+		case ctx.Err() != nil:
+			return nil
+
+		case err == nil:
+			lastSuccess = start
+
+		case isPartialFailure(err):
+			log.Printf("Merging partially failed: %v", err)
+			lastSuccess = start
+
+		case lastSuccess.IsZero():
+			log.Printf("Merging failed: %v", err)
+
+		default:
+			log.Printf("Merging failed (last success at %s): %v", lastSuccess.Format(time.RFC3339), err)
+		}
+
+		delay := interval - time.Since(start)
+
+		if jitter > 0 {
+			delay += rand.N(jitter)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(delay):
+		}
+	}
+}
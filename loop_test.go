@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunPeriodically(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	var calls int
+
+	err := runPeriodically(ctx, time.Millisecond, time.Millisecond, func(context.Context) error {
+		calls++
+
+		if calls == 5 {
+			cancel()
+		}
+
+		if calls%2 == 0 {
+			return errors.New("test")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		t.Errorf("runPeriodically() failed: %v", err)
+	}
+
+	if calls != 5 {
+		t.Errorf("Function invoked %d times, want 5", calls)
+	}
+}
@@ -1,72 +1,595 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 )
 
 var stdoutWriter io.Writer = os.Stdout
 
 type writeFunc func(io.Writer) error
 
-func withOutput(path string, fn writeFunc) error {
+type outputOptions struct {
+	// Keep an existing file, including its modification time, when its
+	// content would not change.
+	onlyIfChanged bool
+
+	// Permissions of the output file. Zero preserves the mode of an existing
+	// file and uses 0644 for new files.
+	mode os.FileMode
+
+	// Owner and group of the output file given as names or numeric IDs.
+	// Empty values leave them unchanged. Not supported on Windows.
+	owner string
+	group string
+
+	// Don't flush the content of the output file to stable storage before
+	// replacing the destination. Faster, but a crash may leave an empty or
+	// partially written file behind. Not supported on Windows, where files
+	// are never synced.
+	skipFileSync bool
+
+	// Sync the parent directory after replacing the output file.
+	syncDir bool
+}
+
+// fileModeFlag parses file permissions given in octal notation.
+type fileModeFlag os.FileMode
+
+var _ flag.Value = (*fileModeFlag)(nil)
+
+func (m *fileModeFlag) String() string {
+	if m == nil || *m == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%04o", uint32(*m))
+}
+
+func (m *fileModeFlag) Set(value string) error {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode == 0 || mode > uint64(os.ModePerm) {
+		return fmt.Errorf("invalid file mode %q", value)
+	}
+
+	*m = fileModeFlag(mode)
+
+	return nil
+}
+
+func withOutput(path string, opts outputOptions, fn writeFunc) error {
 	if path == "" {
 		return fn(stdoutWriter)
 	}
 
-	return withFileOutput(path, fn)
+	return withFileOutput(path, opts, fn)
+}
+
+// fileHasContent reports whether the file at the given path exists and has
+// exactly the given content.
+func fileHasContent(path string, content []byte) (bool, error) {
+	return fileMatches(path, bytes.NewReader(content), int64(len(content)))
+}
+
+// fileMatches reports whether the file at the given path exists and has the
+// same content as the reader. The size of the content must be given.
+func fileMatches(path string, content io.Reader, size int64) (bool, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return false, err
+	}
+
+	defer fh.Close()
+
+	if fi, err := fh.Stat(); err != nil {
+		return false, err
+	} else if !fi.Mode().IsRegular() || fi.Size() != size {
+		return false, nil
+	}
+
+	buf := make([]byte, 32*1024)
+	expected := make([]byte, len(buf))
+
+	for {
+		n, err := io.ReadFull(fh, buf)
+		if err == io.ErrUnexpectedEOF {
+			err = io.EOF
+		} else if err != nil && err != io.EOF {
+			return false, err
+		}
+
+		if m, err := io.ReadFull(content, expected[:n]); err != nil && err != io.EOF {
+			return false, err
+		} else if !bytes.Equal(buf[:n], expected[:m]) {
+			return false, nil
+		}
+
+		if err == io.EOF {
+			return true, nil
+		}
+	}
 }
 
 type cliFlags struct {
 	showVersion     bool
 	showInputs      bool
+	configFile      string
 	outputFile      string
+	outputTargets   outputTargetsFlag
 	dirs            bool
 	dirEntryPattern string
+	maxAge          time.Duration
+	inputMetrics    bool
+	keepGoing       bool
+	errorReport     string
+	listenAddress   string
+	metricsPath     string
+	watch           bool
+	watchDebounce   time.Duration
+	interval        time.Duration
+	intervalJitter  time.Duration
+	mergeTimeout    time.Duration
+	selfMetrics     bool
+	onlyIfChanged   bool
+	outputMode      fileModeFlag
+	outputOwner     string
+	outputGroup     string
+	outputSyncFile  bool
+	outputSyncDir   bool
+	validateOutput  bool
+	limits          mergeLimits
+	spillThreshold  int64
+	spillDir        string
+	lock            bool
+	lockInputs      bool
+	lockTimeout     time.Duration
+	commandTimeout  time.Duration
+	socketTimeout   time.Duration
 }
 
 func (f *cliFlags) register(fs *flag.FlagSet) {
 	fs.BoolVar(&f.showVersion, "version", false, "Output version information and exit")
 	fs.BoolVar(&f.showInputs, "show-inputs", false, "Emit comment with paths of input files")
+	fs.StringVar(&f.configFile, "config", "", "Read merge jobs from the given YAML file instead of the command line")
 	fs.StringVar(&f.outputFile, "output", "", "Write merged metrics to given file instead of standard output")
+	fs.Var(&f.outputTargets, "output-target", `Additionally write merged metrics to the given target; may be repeated. Format is "path=<file>[,format=text|openmetrics][,include=<regexp>][,exclude=<regexp>]" with "-" as the path for standard output. Regular expressions are matched against family names`)
+	fs.BoolVar(&f.onlyIfChanged, "only-if-changed", false, "Keep the output file, including its modification time, if its content would not change (mode and ownership are still applied)")
+	fs.Var(&f.outputMode, "output-mode", "Permissions of the output file in octal notation (default is to keep the mode of an existing file or 0644)")
+	fs.StringVar(&f.outputOwner, "output-owner", "", "Change the owner of the output file to the given user name or ID (not supported on Windows)")
+	fs.StringVar(&f.outputGroup, "output-group", "", "Change the group of the output file to the given group name or ID (not supported on Windows)")
+	fs.BoolVar(&f.outputSyncFile, "output-sync-file", true, "Sync the content of the output file before replacing the destination")
+	fs.BoolVar(&f.outputSyncDir, "output-sync-dir", false, "Sync the directory containing the output file after replacing it")
+	fs.BoolVar(&f.lock, "lock", false, fmt.Sprintf("Serialize runs writing the same output using an advisory lock on a file with the %q suffix next to the output (not supported on Windows)", lockFileSuffix))
+	fs.BoolVar(&f.lockInputs, "lock-inputs", false, "Acquire a shared advisory lock on input files while reading them (not supported on Windows)")
+	fs.DurationVar(&f.lockTimeout, "lock-timeout", 0, "Maximum time to wait for each lock (zero waits until --merge-timeout, if any)")
+	fs.DurationVar(&f.commandTimeout, "command-timeout", defaultCommandInputTimeout, fmt.Sprintf("Maximum run time of command inputs given as %q", commandInputPrefix+"<program> [args...]"))
+	fs.DurationVar(&f.socketTimeout, "socket-timeout", defaultHTTPInputTimeout, fmt.Sprintf("Maximum duration of HTTP requests to inputs given as %q", unixSocketInputPrefix+"<socket>[:<path>]"))
 	fs.BoolVar(&f.dirs, "dirs", false, "Read metrics from regular files in directories given as command arguments")
 	fs.StringVar(&f.dirEntryPattern, "dir-entry-pattern", "[^.]*.prom", "Glob pattern for directory entries")
+	fs.BoolVar(&f.inputMetrics, "input-metrics", false, "Add generated metrics describing each input (modification time, series count, parse status)")
+	fs.BoolVar(&f.selfMetrics, "self-metrics", false, "Add generated metrics describing the merge (duration, inputs, bytes read, series and families)")
+	fs.BoolVar(&f.validateOutput, "validate", false, "Check the merged metrics for inconsistencies (duplicate series, malformed histograms, invalid counter values) and refuse to write them on failure")
+	fs.BoolVar(&f.keepGoing, "keep-going", false, fmt.Sprintf("Skip inputs which can't be read or parsed and exit with status %d after writing the output", exitCodePartialFailure))
+	fs.StringVar(&f.errorReport, "error-report", "", "Write a JSON report describing failures to given file (\"-\" for standard output, requires all outputs to be files)")
+	fs.Int64Var(&f.spillThreshold, "spill-threshold", 0, "Number of parsed input bytes after which merged metrics are moved to temporary files; not a bound on memory usage (zero keeps everything in memory)")
+	fs.StringVar(&f.spillDir, "spill-dir", "", "Directory for temporary files used with --spill-threshold (default is the system temporary directory)")
+	fs.IntVar(&f.limits.series, "limit-series", 0, "Maximum number of series in the merged result (zero disables the limit)")
+	fs.IntVar(&f.limits.seriesPerFamily, "limit-series-per-family", 0, "Maximum number of series per metric family (zero disables the limit)")
+	fs.IntVar(&f.limits.labelsPerSeries, "limit-labels-per-series", 0, "Maximum number of labels per series (zero disables the limit)")
+	fs.IntVar(&f.limits.labelValueLength, "limit-label-value-length", 0, "Maximum length of label values in bytes (zero disables the limit)")
+	fs.Int64Var(&f.limits.inputBytes, "limit-input-bytes", 0, "Maximum number of bytes read from all inputs (zero disables the limit)")
+	f.limits.policy = limitPolicyFail
+	fs.Var(&f.limits.policy, "limit-policy", fmt.Sprintf("Handling of exceeded limits: %q aborts, %q drops offending series, %q skips the offending input", limitPolicyFail, limitPolicyTruncate, limitPolicyDrop))
+	fs.DurationVar(&f.maxAge, "max-age", 0, "Skip input files not modified within the given duration; --input-metrics still reports their modification time (zero disables the check)")
+	fs.StringVar(&f.listenAddress, "listen-address", "", "Serve merged metrics via HTTP on given address instead of writing them once (e.g. \":9999\")")
+	fs.StringVar(&f.metricsPath, "metrics-path", "/metrics", "Path under which to serve merged metrics")
+	fs.BoolVar(&f.watch, "watch", false, "Keep running and rewrite the output whenever an input changes")
+	fs.DurationVar(&f.watchDebounce, "watch-debounce", time.Second, "Time to wait for further changes before rewriting the output in watch mode")
+	fs.DurationVar(&f.interval, "interval", 0, "Keep running and rewrite the output at the given interval")
+	fs.DurationVar(&f.intervalJitter, "interval-jitter", 0, "Add a random delay of up to the given duration to each interval")
+	fs.DurationVar(&f.mergeTimeout, "merge-timeout", 0, "Abort merging if it takes longer than the given duration (zero disables the timeout)")
 }
 
-func (f *cliFlags) inputs(fs *flag.FlagSet) ([]inputWrapper, error) {
-	if f.dirs {
-		return inputWrappersFromDirs(flag.Args(), f.dirEntryPattern)
+// longRunningModes returns the names of the enabled flags which keep the
+// program running.
+func (f *cliFlags) longRunningModes() []string {
+	var result []string
+
+	if f.listenAddress != "" {
+		result = append(result, "--listen-address")
+	}
+
+	if f.watch {
+		result = append(result, "--watch")
 	}
 
-	var paths []string
+	if f.interval > 0 {
+		result = append(result, "--interval")
+	}
+
+	return result
+}
+
+// jobFlags are the flags describing the single merge job given on the command
+// line. They can't be combined with --config.
+var jobFlags = map[string]struct{}{
+	"command-timeout":          {},
+	"dir-entry-pattern":        {},
+	"dirs":                     {},
+	"input-metrics":            {},
+	"keep-going":               {},
+	"limit-input-bytes":        {},
+	"limit-label-value-length": {},
+	"limit-labels-per-series":  {},
+	"limit-policy":             {},
+	"limit-series":             {},
+	"limit-series-per-family":  {},
+	"lock":                     {},
+	"lock-inputs":              {},
+	"max-age":                  {},
+	"only-if-changed":          {},
+	"output":                   {},
+	"output-group":             {},
+	"output-mode":              {},
+	"output-owner":             {},
+	"output-sync-dir":          {},
+	"output-sync-file":         {},
+	"output-target":            {},
+	"self-metrics":             {},
+	"show-inputs":              {},
+	"socket-timeout":           {},
+	"validate":                 {},
+}
+
+func (f *cliFlags) validate(fs *flag.FlagSet) error {
+	modes := f.longRunningModes()
+
+	if len(modes) > 1 {
+		return &usageError{fmt.Errorf("%s can't be combined", strings.Join(modes, " and "))}
+	}
+
+	if f.configFile != "" {
+		if fs.NArg() > 0 {
+			return &usageError{errors.New("input arguments can't be combined with --config")}
+		}
+
+		var err error
+
+		fs.Visit(func(fl *flag.Flag) {
+			if _, ok := jobFlags[fl.Name]; ok && err == nil {
+				err = &usageError{fmt.Errorf("--%s can't be combined with --config", fl.Name)}
+			}
+		})
+
+		return err
+	}
+
+	job := f.job(fs.Args())
+
+	if f.lock && len(job.outputPaths()) == 0 {
+		return &usageError{errors.New("--lock requires --output")}
+	}
+
+	if f.errorReport == stdinPlaceholder && f.listenAddress == "" && job.writesStdout() {
+		// The report would be mixed with the merged metrics
+		return &usageError{errors.New("--error-report - requires all outputs to be files")}
+	}
+
+	if len(modes) == 0 {
+		return nil
+	}
+
+	if job.readsStdin() {
+		return &usageError{fmt.Errorf("standard input can't be read repeatedly with %s", modes[0])}
+	}
+
+	if f.listenAddress != "" {
+		if f.outputFile != "" {
+			return &usageError{errors.New("--output can't be combined with --listen-address")}
+		}
+
+		if len(f.outputTargets) > 0 {
+			return &usageError{errors.New("--output-target can't be combined with --listen-address")}
+		}
+	} else if len(job.outputPaths()) == 0 {
+		return &usageError{fmt.Errorf("%s requires --output", modes[0])}
+	}
+
+	return nil
+}
+
+// validateJobs checks jobs loaded from a configuration file against the mode
+// of operation.
+func (f *cliFlags) validateJobs(jobs []*mergeJob) error {
+	modes := f.longRunningModes()
+
+	for _, j := range jobs {
+		var err error
+
+		switch {
+		case f.listenAddress != "":
+			if len(j.outputs) > 0 {
+				err = errors.New("outputs can't be combined with --listen-address")
+			}
+
+		case len(j.outputs) == 0:
+			err = errors.New("no outputs")
+
+		case len(modes) > 0 && len(j.outputPaths()) == 0:
+			err = fmt.Errorf("%s requires a file output", modes[0])
+
+		case f.errorReport == stdinPlaceholder && j.writesStdout():
+			err = errors.New("standard output can't be combined with --error-report -")
+		}
+
+		if err != nil {
+			return &usageError{fmt.Errorf("job %q: %w", j.name, err)}
+		}
+	}
+
+	return nil
+}
 
-	if fs.NArg() == 0 {
-		paths = []string{stdinPlaceholder}
+// job returns the merge job described by the flags and the input arguments.
+func (f *cliFlags) job(args []string) *mergeJob {
+	opts := inputOptions{
+		maxAge:         f.maxAge,
+		lock:           f.lockInputs,
+		lockTimeout:    f.lockTimeout,
+		commandTimeout: f.commandTimeout,
+		socketTimeout:  f.socketTimeout,
+	}
+
+	var sources []inputSource
+
+	if f.dirs {
+		for _, i := range args {
+			sources = append(sources, inputSource{
+				dir:     i,
+				pattern: f.dirEntryPattern,
+				opts:    opts,
+			})
+		}
 	} else {
-		paths = fs.Args()
+		if len(args) == 0 {
+			args = []string{stdinPlaceholder}
+		}
+
+		sources = append(sources, inputSource{
+			args: args,
+			opts: opts,
+		})
+	}
+
+	return &mergeJob{
+		sources:     sources,
+		opts:        f.mergeOptions(),
+		outputs:     f.outputs(),
+		showInputs:  f.showInputs,
+		lock:        f.lock,
+		lockTimeout: f.lockTimeout,
+		timeout:     f.mergeTimeout,
+	}
+}
+
+// jobs returns the merge jobs from the configuration file, if any, or the
+// single job described by the command line.
+func (f *cliFlags) jobs(fs *flag.FlagSet) ([]*mergeJob, error) {
+	if f.configFile == "" {
+		return []*mergeJob{f.job(fs.Args())}, nil
+	}
+
+	jobs, err := loadConfig(f.configFile, jobDefaults{
+		spillThreshold: f.spillThreshold,
+		spillDir:       f.spillDir,
+		lockTimeout:    f.lockTimeout,
+		timeout:        f.mergeTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := f.validateJobs(jobs); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+func (f *cliFlags) outputOptions() outputOptions {
+	return outputOptions{
+		onlyIfChanged: f.onlyIfChanged,
+		mode:          os.FileMode(f.outputMode),
+		owner:         f.outputOwner,
+		group:         f.outputGroup,
+		skipFileSync:  !f.outputSyncFile,
+		syncDir:       f.outputSyncDir,
+	}
+}
+
+func (f *cliFlags) mergeOptions() mergeOptions {
+	return mergeOptions{
+		inputMetrics:   f.inputMetrics,
+		keepGoing:      f.keepGoing,
+		selfMetrics:    f.selfMetrics,
+		validate:       f.validateOutput,
+		limits:         f.limits,
+		spillThreshold: f.spillThreshold,
+		spillDir:       f.spillDir,
+	}
+}
+
+// metricsHandler returns the HTTP handler for the given jobs and the path
+// under which it's served.
+func (f *cliFlags) metricsHandler(jobs []*mergeJob) (string, http.Handler) {
+	if f.configFile != "" {
+		return strings.TrimSuffix(f.metricsPath, "/") + "/", newJobsHandler(f.metricsPath, jobs)
+	}
+
+	return f.metricsPath, jobs[0].handler()
+}
+
+func run(ctx context.Context, cf *cliFlags, fs *flag.FlagSet) error {
+	if err := cf.validate(fs); err != nil {
+		return err
+	}
+
+	load := func() ([]*mergeJob, error) {
+		return cf.jobs(fs)
+	}
+
+	jobs, err := load()
+	if err != nil {
+		return err
+	}
+
+	if len(cf.longRunningModes()) == 0 {
+		return runJobs(ctx, jobs)
 	}
 
-	return inputWrappersFromPaths(paths), nil
+	// Reload the configuration on SIGHUP
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	defer signal.Stop(reload)
+
+	if cf.listenAddress != "" {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		path, handler := cf.metricsHandler(jobs)
+		swappable := newSwappableHandler(handler)
+
+		// Only the handler is replaced, the listening socket is kept
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+
+				case <-reload:
+					if next, ok := reloadJobs(jobs, load); ok {
+						jobs = next
+
+						_, handler := cf.metricsHandler(jobs)
+						swappable.set(handler)
+					}
+				}
+			}
+		}()
+
+		return serve(ctx, cf.listenAddress, path, swappable)
+	}
+
+	return runWithReload(ctx, reload, jobs, load, func(ctx context.Context, jobs []*mergeJob) error {
+		if cf.watch {
+			dirs, match := watchTargets(jobs)
+
+			return watchInputs(ctx, dirs, match, cf.watchDebounce, func(ctx context.Context) error {
+				return runJobs(ctx, jobs)
+			})
+		}
+
+		return runPeriodically(ctx, cf.interval, cf.intervalJitter, func(ctx context.Context) error {
+			return runJobs(ctx, jobs)
+		})
+	})
+}
+
+func writeFailureReport(path string, report failureReport) error {
+	if path == stdinPlaceholder {
+		path = ""
+	}
+
+	return withOutput(path, outputOptions{}, report.write)
+}
+
+// exitWithReport terminates the program with the exit code appropriate for the
+// error. The failure report is written if a path is given.
+func exitWithReport(err error, reportPath string) {
+	report := newFailureReport(err)
+
+	if err != nil {
+		log.Print(err)
+	}
+
+	if reportPath != "" {
+		if err := writeFailureReport(reportPath, report); err != nil {
+			log.Printf("Writing error report failed: %v", err)
+		}
+	}
+
+	os.Exit(report.ExitCode)
 }
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+
+	if info := lookupSubcommand(os.Args[1:]); info != nil {
+		err := runSubcommand(ctx, info, os.Args[2:])
+		stop()
+
+		exitWithReport(err, "")
+	}
+
 	flag.Usage = func() {
 		w := flag.CommandLine.Output()
 
-		fmt.Fprintf(w, "Usage: %s [file...]\n", os.Args[0])
+		fmt.Fprintf(w, "Usage: %s [flags] [file...]\n", os.Args[0])
+		fmt.Fprintf(w, "       %s <command> [flags] [args...]\n", os.Args[0])
 		fmt.Fprintln(w, `
 Combine one or multiple Prometheus text format inputs. Metric families sharing
 a name must also have the same type. The lexicographically lowest help string
-per family is used. The resulting metrics are not validated.
+per family is used. The resulting metrics are only checked for consistency with
+--validate, in which case an existing output is kept if checks fail. Use the
+"check" command to also lint naming conventions.
 
 If no input files are given standard input is read. Use "-" as a placeholder to
-combine standard input with regular files.
+combine standard input with regular files. Inputs of the form
+"exec:<program> [args...]" run the program and read its standard output; the
+arguments are split on whitespace without support for quoting. A non-zero exit
+status is an input error and standard error is logged. Inputs of the form
+"unix:<socket>[:<path>]" are fetched via HTTP from a server listening on a Unix
+domain socket (the path defaults to "/metrics"). These prefixes are only
+recognized in command line arguments, never in file names from directories or
+configuration files.
+
+With --listen-address the program keeps running and reads and merges the
+inputs on every HTTP request to the metrics path. The response format is
+negotiated with the client (text, OpenMetrics or protocol buffers).
 
-Flags:`)
+With --watch the output is rewritten whenever an input file changes. With
+--interval the output is rewritten periodically.
+
+With --config one or more merge jobs, each with their own inputs, filters,
+relabel rules and outputs, are read from a YAML file. Flags describing inputs,
+merging and outputs can't be combined with --config. When serving via HTTP each
+job is available at "<metrics-path>/<job name>".
+
+In long-running modes SIGHUP reloads the configuration. An invalid
+configuration is logged and the previous one kept.
+
+Commands (use "<command> --help" for details, "./<command>" to read a file
+named like a command):`)
+		fmt.Fprintln(w, subcommandsHelp())
+		fmt.Fprintln(w, "Exit codes:")
+		fmt.Fprintln(w, exitCodesHelp())
+		fmt.Fprintln(w, "Flags:")
 		flag.PrintDefaults()
 	}
 
@@ -81,19 +604,8 @@ Flags:`)
 		return
 	}
 
-	inputs, err := cf.inputs(flag.CommandLine)
-	if err != nil {
-		log.Fatal(err)
-	}
+	err := run(ctx, &cf, flag.CommandLine)
+	stop()
 
-	merged, err := readAndMerge(context.Background(), inputs)
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	if err := withOutput(cf.outputFile, func(w io.Writer) error {
-		return merged.write(w, cf.showInputs)
-	}); err != nil {
-		log.Fatalf("Writing output failed: %v", err)
-	}
+	exitWithReport(err, cf.errorReport)
 }
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"regexp"
+	"testing"
+)
+
+func TestCliFlagsValidate(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		wantErr *regexp.Regexp
+	}{
+		{name: "defaults"},
+		{
+			name: "stdin with output",
+			args: []string{"--output", "out.prom", "-"},
+		},
+		{
+			name: "serve",
+			args: []string{"--listen-address", ":0", "a.prom"},
+		},
+		{
+			name:    "serve stdin",
+			args:    []string{"--listen-address", ":0"},
+			wantErr: regexp.MustCompile(`^standard input can't be read repeatedly with --listen-address$`),
+		},
+		{
+			name:    "serve with output",
+			args:    []string{"--listen-address", ":0", "--output", "out.prom", "a.prom"},
+			wantErr: regexp.MustCompile(`^--output can't be combined`),
+		},
+		{
+			name: "watch",
+			args: []string{"--watch", "--output", "out.prom", "a.prom"},
+		},
+		{
+			name: "watch with output target",
+			args: []string{"--watch", "--output-target", "path=out.prom,format=openmetrics", "a.prom"},
+		},
+		{
+			name:    "serve with output target",
+			args:    []string{"--listen-address", ":0", "--output-target", "path=-", "a.prom"},
+			wantErr: regexp.MustCompile(`^--output-target can't be combined`),
+		},
+		{
+			name:    "watch without output",
+			args:    []string{"--watch", "a.prom"},
+			wantErr: regexp.MustCompile(`^--watch requires --output$`),
+		},
+		{
+			name:    "interval stdin",
+			args:    []string{"--interval", "1m", "--output", "out.prom", "a.prom", "-"},
+			wantErr: regexp.MustCompile(`^standard input can't be read repeatedly with --interval$`),
+		},
+		{
+			name: "interval dirs",
+			args: []string{"--interval", "1m", "--output", "out.prom", "--dirs"},
+		},
+		{
+			name: "lock",
+			args: []string{"--lock", "--output", "out.prom", "a.prom"},
+		},
+		{
+			name:    "lock without output",
+			args:    []string{"--lock", "a.prom"},
+			wantErr: regexp.MustCompile(`^--lock requires --output$`),
+		},
+		{
+			name:    "multiple modes",
+			args:    []string{"--watch", "--interval", "1m", "--output", "out.prom", "a.prom"},
+			wantErr: regexp.MustCompile(`^--watch and --interval can't be combined$`),
+		},
+		{
+			name: "error report with output",
+			args: []string{"--error-report", "-", "--output", "out.prom", "a.prom"},
+		},
+		{
+			name:    "error report on standard output",
+			args:    []string{"--error-report", "-", "a.prom"},
+			wantErr: regexp.MustCompile(`^--error-report - requires all outputs to be files$`),
+		},
+		{
+			name: "config",
+			args: []string{"--config", "config.yaml", "--watch", "--spill-threshold", "1000"},
+		},
+		{
+			name:    "config with inputs",
+			args:    []string{"--config", "config.yaml", "a.prom"},
+			wantErr: regexp.MustCompile(`^input arguments can't be combined with --config$`),
+		},
+		{
+			name:    "config with output",
+			args:    []string{"--config", "config.yaml", "--output", "out.prom"},
+			wantErr: regexp.MustCompile(`^--output can't be combined with --config$`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var cf cliFlags
+
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+
+			cf.register(fs)
+
+			if err := fs.Parse(tc.args); err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+
+			err := cf.validate(fs)
+
+			if tc.wantErr != nil {
+				var usageErr *usageError
+
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Errorf("validate() failed with %v, want match for %q", err, tc.wantErr.String())
+				} else if !errors.As(err, &usageErr) {
+					t.Errorf("validate() returned %#v, want usage error", err)
+				}
+			} else if err != nil {
+				t.Errorf("validate() failed with %v", err)
+			}
+		})
+	}
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/prometheus/common/expfmt"
 	"golang.org/x/sync/errgroup"
@@ -13,18 +16,27 @@ import (
 	dto "github.com/prometheus/client_model/go"
 )
 
+// checkFamily returns an error if the two metric families can't be combined.
+func checkFamily(dst *dto.MetricFamily, src *dto.MetricFamily) error {
+	if dst.GetName() != src.GetName() {
+		return fmt.Errorf("name mismatch (dst is %q, src is %q)", dst.GetName(), src.GetName())
+	}
+
+	if dst.GetType() != src.GetType() {
+		return fmt.Errorf("type mismatch (dst is %v, src is %v)", dst.GetType(), src.GetType())
+	}
+
+	return nil
+}
+
 // mergeFamily combines two metric families. The destination is modified and returned.
 func mergeFamily(dst *dto.MetricFamily, src *dto.MetricFamily) (*dto.MetricFamily, error) {
 	if dst == nil {
 		return src, nil
 	}
 
-	if dst.GetName() != src.GetName() {
-		return nil, fmt.Errorf("name mismatch (dst is %q, src is %q)", dst.GetName(), src.GetName())
-	}
-
-	if dst.GetType() != src.GetType() {
-		return nil, fmt.Errorf("type mismatch (dst is %v, src is %v)", dst.GetType(), src.GetType())
+	if err := checkFamily(dst, src); err != nil {
+		return nil, err
 	}
 
 	if dst.GetHelp() > src.GetHelp() && len(strings.TrimSpace(src.GetHelp())) > 0 {
@@ -39,6 +51,85 @@ func mergeFamily(dst *dto.MetricFamily, src *dto.MetricFamily) (*dto.MetricFamil
 type mergedInputs struct {
 	names    []string
 	families []*dto.MetricFamily
+
+	// Errors of inputs excluded from the result.
+	failures []error
+
+	stats mergeStats
+
+	// Metrics moved to temporary files. If set the families only contain the
+	// metrics merged after the last spill; use each to access all metrics.
+	spill *spillStore
+}
+
+// each invokes the function for every family in order. Metrics moved to
+// temporary files are read back one family at a time.
+func (c *mergedInputs) each(fn func(*dto.MetricFamily) error) error {
+	if c.spill == nil {
+		for _, mf := range c.families {
+			if err := fn(mf); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	readers, err := c.spill.open()
+	if err != nil {
+		return err
+	}
+
+	defer closeSpillReaders(readers)
+
+	for _, mf := range c.families {
+		var metrics []*dto.Metric
+
+		for _, r := range readers {
+			spilled, err := r.take(mf.GetName())
+			if err != nil {
+				return err
+			}
+
+			metrics = append(metrics, spilled...)
+		}
+
+		if err := fn(&dto.MetricFamily{
+			Name:   mf.Name,
+			Help:   mf.Help,
+			Type:   mf.Type,
+			Unit:   mf.Unit,
+			Metric: append(metrics, mf.Metric...),
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// filtered returns a view containing only the families accepted by the
+// function. Temporary files are shared with the original.
+func (c *mergedInputs) filtered(keep func(name string) bool) *mergedInputs {
+	result := *c
+	result.families = nil
+
+	for _, mf := range c.families {
+		if keep(mf.GetName()) {
+			result.families = append(result.families, mf)
+		}
+	}
+
+	return &result
+}
+
+// close removes temporary files, if any.
+func (c *mergedInputs) close() error {
+	if c.spill == nil {
+		return nil
+	}
+
+	return c.spill.remove()
 }
 
 func (c *mergedInputs) write(w io.Writer, includeNames bool) error {
@@ -52,48 +143,309 @@ func (c *mergedInputs) write(w io.Writer, includeNames bool) error {
 		io.WriteString(w, "\n")
 	}
 
-	for _, mf := range c.families {
+	return c.each(func(mf *dto.MetricFamily) error {
 		if _, err := expfmt.MetricFamilyToText(w, mf); err != nil {
 			return fmt.Errorf("%s: %w", mf.GetName(), err)
 		}
+
+		return nil
+	})
+}
+
+// encode writes all families in the given exposition format.
+func (c *mergedInputs) encode(w io.Writer, format expfmt.Format) error {
+	enc := expfmt.NewEncoder(w, format)
+
+	if err := c.each(func(mf *dto.MetricFamily) error {
+		if err := enc.Encode(mf); err != nil {
+			return fmt.Errorf("%s: %w", mf.GetName(), err)
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if closer, ok := enc.(expfmt.Closer); ok {
+		return closer.Close()
 	}
 
 	return nil
 }
 
+// conflictPolicy determines how families which can't be merged with an
+// existing family of the same name are handled.
+type conflictPolicy string
+
+const (
+	// Abort the merge.
+	conflictPolicyFail conflictPolicy = "fail"
+
+	// Exclude the whole input.
+	conflictPolicySkipInput conflictPolicy = "skip_input"
+
+	// Exclude only the conflicting families of the input.
+	conflictPolicySkipFamily conflictPolicy = "skip_family"
+)
+
+type mergeOptions struct {
+	// Add generated families describing each input.
+	inputMetrics bool
+
+	// Exclude inputs which can't be read or parsed instead of failing.
+	keepGoing bool
+
+	// Add generated families describing the merge itself.
+	selfMetrics bool
+
+	// Fail instead of returning a result with inconsistent metrics, e.g.
+	// duplicate series or malformed histograms.
+	validate bool
+
+	limits mergeLimits
+
+	// Move metrics to temporary files once the size of the inputs merged
+	// in memory exceeds the given number of bytes. The size of the parsed
+	// metrics differs from the input size, so this is only an approximate
+	// threshold. Zero disables spilling.
+	spillThreshold int64
+
+	// Directory for temporary files, empty for the default.
+	spillDir string
+
+	// Handling of conflicting families. Defaults to failing.
+	onConflict conflictPolicy
+
+	// Only families accepted by the function are merged if set.
+	familyFilter func(name string) bool
+
+	// Rules applied to all series before merging.
+	relabel []*relabelRule
+}
+
+func (o mergeOptions) conflictPolicy() conflictPolicy {
+	if o.onConflict != "" {
+		return o.onConflict
+	}
+
+	return conflictPolicyFail
+}
+
 type metricsMerger struct {
+	opts       mergeOptions
+	budget     *inputBudget
+	start      time.Time
+	stats      mergeStats
+	series     int
 	inputNames []string
-	byName     map[string]*dto.MetricFamily
+	inputs     []inputStatus
+	failures   []error
+
+	// Families retain their name, type and help after their metrics have
+	// been spilled.
+	byName map[string]*dto.MetricFamily
+
+	// Number of series per family, including spilled series.
+	familySeries map[string]int
+
+	// Size of the inputs merged in memory since the last spill.
+	unspilledBytes int64
+	spill          *spillStore
 }
 
-func newMetricsMerger() *metricsMerger {
+func newMetricsMerger(opts mergeOptions) *metricsMerger {
 	return &metricsMerger{
-		byName: make(map[string]*dto.MetricFamily),
+		opts:         opts,
+		start:        time.Now(),
+		byName:       make(map[string]*dto.MetricFamily),
+		familySeries: make(map[string]int),
 	}
 }
 
-func (m *metricsMerger) append(input parsedInput) error {
-	m.inputNames = append(m.inputNames, input.name)
-
-	for _, mf := range input.families {
+func (m *metricsMerger) mergeFamilies(source string, families map[string]*dto.MetricFamily) error {
+	for _, mf := range families {
 		var err error
 
 		name := mf.GetName()
 
+		if dst := m.byName[name]; dst != nil && dst.GetHelp() != mf.GetHelp() &&
+			len(strings.TrimSpace(dst.GetHelp())) > 0 && len(strings.TrimSpace(mf.GetHelp())) > 0 {
+			m.stats.helpConflicts++
+		}
+
 		m.byName[name], err = mergeFamily(m.byName[name], mf)
 
 		if err != nil {
-			return fmt.Errorf("family %q from %q: %w", name, input.name, err)
+			return &mergeConflictError{family: name, input: source, err: err}
 		}
 	}
 
 	return nil
 }
 
-func (m *metricsMerger) finalize() *mergedInputs {
-	families := make([]*dto.MetricFamily, 0, len(m.byName))
+// checkFamilies verifies whether all given families can be merged without
+// modifying any.
+func (m *metricsMerger) checkFamilies(source string, families map[string]*dto.MetricFamily) error {
+	for name, mf := range families {
+		if dst := m.byName[name]; dst != nil {
+			if err := checkFamily(dst, mf); err != nil {
+				return &mergeConflictError{family: name, input: source, err: err}
+			}
+		}
+	}
+
+	return nil
+}
+
+// skipConflicting removes families which can't be merged from the input.
+func (m *metricsMerger) skipConflicting(input parsedInput) {
+	for name, mf := range input.families {
+		if dst := m.byName[name]; dst != nil {
+			if err := checkFamily(dst, mf); err != nil {
+				log.Printf("Skipped family: %v", &mergeConflictError{family: name, input: input.name, err: err})
+				delete(input.families, name)
+			}
+		}
+	}
+}
+
+// transform applies the family filter and relabel rules to the input.
+func (m *metricsMerger) transform(input parsedInput) {
+	if m.opts.familyFilter != nil {
+		for name := range input.families {
+			if !m.opts.familyFilter(name) {
+				delete(input.families, name)
+			}
+		}
+	}
+
+	relabelFamilies(input.families, m.opts.relabel)
+}
+
+func (m *metricsMerger) append(input parsedInput) error {
+	if input.stale {
+		// Only the modification time is reported
+		m.inputs = append(m.inputs, inputStatus{name: input.name, modTime: input.modTime, stale: true})
+		return nil
+	}
+
+	var limitErr *limitError
+
+	if errors.As(input.err, &limitErr) {
+		if p := m.opts.limits.policy; p != limitPolicyTruncate && p != limitPolicyDrop {
+			return input.err
+		}
+	}
+
+	if input.err == nil {
+		m.transform(input)
+
+		if err := m.checkLimits(input); err != nil {
+			if p := m.opts.limits.policy; p != limitPolicyTruncate && p != limitPolicyDrop {
+				return err
+			}
+
+			// Limits not enforceable by truncation exclude the whole input
+			input.err = err
+		}
+	}
+
+	if input.err == nil {
+		switch m.opts.conflictPolicy() {
+		case conflictPolicySkipInput:
+			// Conflicting inputs are excluded as a whole
+			input.err = m.checkFamilies(input.name, input.families)
+
+		case conflictPolicySkipFamily:
+			m.skipConflicting(input)
+		}
+	}
+
+	if input.err != nil {
+		m.inputs = append(m.inputs, inputStatus{name: input.name})
+		m.failures = append(m.failures, input.err)
+		m.stats.skippedInputs++
+		return nil
+	}
+
+	m.inputNames = append(m.inputNames, input.name)
+	m.inputs = append(m.inputs, newInputStatus(input))
+	m.stats.inputs++
+	m.stats.bytesRead += input.size
+	m.budget.add(input.size)
+
+	for name, mf := range input.families {
+		m.series += len(mf.GetMetric())
+		m.familySeries[name] += len(mf.GetMetric())
+	}
+
+	if err := m.mergeFamilies(input.name, input.families); err != nil {
+		return err
+	}
+
+	m.unspilledBytes += input.size
+
+	if m.opts.spillThreshold > 0 && m.unspilledBytes > m.opts.spillThreshold {
+		return m.spillFamilies()
+	}
+
+	return nil
+}
+
+// spillFamilies moves the metrics of all families to a temporary file.
+func (m *metricsMerger) spillFamilies() error {
+	if m.spill == nil {
+		m.spill = &spillStore{dir: m.opts.spillDir}
+	}
+
+	if err := m.spill.write(sortedFamilies(m.byName)); err != nil {
+		return err
+	}
 
 	for _, mf := range m.byName {
+		mf.Metric = nil
+	}
+
+	m.unspilledBytes = 0
+
+	return nil
+}
+
+// discard removes temporary files of an unfinished merge.
+func (m *metricsMerger) discard() {
+	if m.spill != nil {
+		m.spill.remove()
+	}
+}
+
+// appendGenerated updates the statistics and adds the families generated from
+// the processed inputs, if any are enabled.
+func (m *metricsMerger) appendGenerated() error {
+	m.stats.families = len(m.byName)
+	m.stats.series = m.series
+
+	m.stats.duration = time.Since(m.start)
+
+	if m.opts.inputMetrics {
+		if err := m.mergeFamilies("input metrics", inputMetricFamilies(m.inputs)); err != nil {
+			return err
+		}
+	}
+
+	if m.opts.selfMetrics {
+		if err := m.mergeFamilies("self metrics", selfMetricFamilies(m.stats)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortedFamilies returns the families sorted by name.
+func sortedFamilies(byName map[string]*dto.MetricFamily) []*dto.MetricFamily {
+	families := make([]*dto.MetricFamily, 0, len(byName))
+
+	for _, mf := range byName {
 		families = append(families, mf)
 	}
 
@@ -102,44 +454,74 @@ func (m *metricsMerger) finalize() *mergedInputs {
 		return families[a].GetName() < families[b].GetName()
 	})
 
+	return families
+}
+
+func (m *metricsMerger) finalize() *mergedInputs {
 	return &mergedInputs{
 		names:    m.inputNames,
-		families: families,
+		families: sortedFamilies(m.byName),
+		failures: m.failures,
+		stats:    m.stats,
+		spill:    m.spill,
 	}
 }
 
-func mergeInputs(ctx context.Context, inputsCh <-chan parsedInput) (*mergedInputs, error) {
-	merger := newMetricsMerger()
+func mergeInputs(ctx context.Context, inputsCh <-chan parsedInput, opts mergeOptions, budget *inputBudget) (*mergedInputs, error) {
+	merger := newMetricsMerger(opts)
+	merger.budget = budget
 
 	for cur := range inputsCh {
 		if err := merger.append(cur); err != nil {
+			merger.discard()
 			return nil, err
 		}
 	}
 
-	return merger.finalize(), nil
+	if err := merger.appendGenerated(); err != nil {
+		merger.discard()
+		return nil, err
+	}
+
+	merged := merger.finalize()
+
+	if opts.validate {
+		if err := validateMerged("merged output", merged); err != nil {
+			merged.close()
+			return nil, err
+		}
+	}
+
+	return merged, nil
 }
 
-func readAndMerge(ctx context.Context, inputs []inputWrapper) (*mergedInputs, error) {
+func readAndMerge(ctx context.Context, inputs []inputWrapper, opts mergeOptions) (*mergedInputs, error) {
 	g, ctx := errgroup.WithContext(ctx)
 
 	parsedCh := make(chan parsedInput)
 
+	// Shared to stop reading inputs exceeding the limit early
+	budget := newInputBudget(opts.limits)
+
 	g.Go(func() error {
 		defer close(parsedCh)
 
-		return readInputs(ctx, inputs, parsedCh)
+		return readInputs(ctx, inputs, opts.keepGoing, budget, parsedCh)
 	})
 
 	var merged *mergedInputs
 
 	g.Go(func() error {
 		var err error
-		merged, err = mergeInputs(ctx, parsedCh)
+		merged, err = mergeInputs(ctx, parsedCh, opts, budget)
 		return err
 	})
 
 	if err := g.Wait(); err != nil {
+		if merged != nil {
+			merged.close()
+		}
+
 		return nil, err
 	}
 
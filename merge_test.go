@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"path/filepath"
 	"regexp"
 	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -182,15 +187,63 @@ requests{kind="get"} 18193
 
 func TestReadAndMerge(t *testing.T) {
 	for _, tc := range []struct {
-		name    string
-		inputs  []inputWrapper
-		want    *mergedInputs
-		wantErr *regexp.Regexp
+		name         string
+		inputs       []inputWrapper
+		opts         mergeOptions
+		want         *mergedInputs
+		wantFailures int
+		wantErr      *regexp.Regexp
 	}{
 		{
 			name: "empty",
 			want: &mergedInputs{},
 		},
+		{
+			name: "input metrics",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt",
+					"# TYPE size GAUGE\nsize 1\n")),
+			},
+			opts: mergeOptions{inputMetrics: true},
+			want: &mergedInputs{
+				names: []string{"a.txt"},
+				families: []*dto.MetricFamily{
+					{
+						Name: newString("size"),
+						Type: dto.MetricType_GAUGE.Enum(),
+						Metric: []*dto.Metric{
+							{Gauge: &dto.Gauge{Value: newFloat64(1)}},
+						},
+					},
+					{
+						Name: newString("textformat_merge_input_parse_success"),
+						Help: newString("Whether the input was read and parsed successfully."),
+						Type: dto.MetricType_GAUGE.Enum(),
+						Metric: []*dto.Metric{
+							{
+								Label: []*dto.LabelPair{
+									{Name: newString("path"), Value: newString("a.txt")},
+								},
+								Gauge: &dto.Gauge{Value: newFloat64(1)},
+							},
+						},
+					},
+					{
+						Name: newString("textformat_merge_input_series"),
+						Help: newString("Number of series read from the input."),
+						Type: dto.MetricType_GAUGE.Enum(),
+						Metric: []*dto.Metric{
+							{
+								Label: []*dto.LabelPair{
+									{Name: newString("path"), Value: newString("a.txt")},
+								},
+								Gauge: &dto.Gauge{Value: newFloat64(1)},
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "single gauge",
 			inputs: []inputWrapper{
@@ -277,12 +330,169 @@ func TestReadAndMerge(t *testing.T) {
 			},
 			wantErr: regexp.MustCompile(`^family "wrong" from "b.txt": type mismatch\b`),
 		},
+		{
+			name: "parse error",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt", "size 1\n")),
+				newReaderInputWrapper(newFakeReaderWithName("bad.txt", "x y z\n")),
+			},
+			wantErr: regexp.MustCompile(`^bad.txt:1:3: expected float as value\b`),
+		},
+		{
+			name: "keep going",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt", "size 1\n")),
+				newReaderInputWrapper(newFakeReaderWithName("bad.txt", "x y z\n")),
+			},
+			opts: mergeOptions{keepGoing: true},
+			want: &mergedInputs{
+				names: []string{"a.txt"},
+				families: []*dto.MetricFamily{
+					{
+						Name: newString("size"),
+						Type: dto.MetricType_UNTYPED.Enum(),
+						Metric: []*dto.Metric{
+							{Untyped: &dto.Untyped{Value: newFloat64(1)}},
+						},
+					},
+				},
+			},
+			wantFailures: 1,
+		},
+		{
+			name: "validate",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt",
+					"# TYPE errors_total COUNTER\nerrors_total 1\n")),
+			},
+			opts: mergeOptions{validate: true},
+			want: &mergedInputs{
+				names: []string{"a.txt"},
+				families: []*dto.MetricFamily{
+					{
+						Name: newString("errors_total"),
+						Type: dto.MetricType_COUNTER.Enum(),
+						Metric: []*dto.Metric{
+							{Counter: &dto.Counter{Value: newFloat64(1)}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "validate duplicate series",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt", "size 1\n")),
+				newReaderInputWrapper(newFakeReaderWithName("b.txt", "size 2\n")),
+			},
+			opts:    mergeOptions{validate: true},
+			wantErr: regexp.MustCompile(`^merged output: validation found 1 problem\(s\)$`),
+		},
+		{
+			name: "keep going with conflict",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt",
+					"# TYPE size GAUGE\nsize 1\n")),
+				newReaderInputWrapper(newFakeReaderWithName("b.txt",
+					"# TYPE size COUNTER\nsize 2\n")),
+			},
+			opts:    mergeOptions{keepGoing: true},
+			wantErr: regexp.MustCompile(`^family "size" from "b.txt": type mismatch\b`),
+		},
+		{
+			name: "skip conflicting input",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt",
+					"# TYPE size GAUGE\nsize 1\n")),
+				newReaderInputWrapper(newFakeReaderWithName("b.txt",
+					"# TYPE aborted COUNTER\naborted 2\n# TYPE size COUNTER\nsize 2\n")),
+				newReaderInputWrapper(newFakeReaderWithName("bad.txt", "x y z\n")),
+			},
+			opts: mergeOptions{keepGoing: true, onConflict: conflictPolicySkipInput},
+			want: &mergedInputs{
+				names: []string{"a.txt"},
+				families: []*dto.MetricFamily{
+					{
+						Name: newString("size"),
+						Type: dto.MetricType_GAUGE.Enum(),
+						Metric: []*dto.Metric{
+							{Gauge: &dto.Gauge{Value: newFloat64(1)}},
+						},
+					},
+				},
+			},
+			wantFailures: 2,
+		},
+		{
+			name: "skip conflicting family",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt",
+					"# TYPE size GAUGE\nsize 1\n")),
+				newReaderInputWrapper(newFakeReaderWithName("b.txt",
+					"# TYPE aborted COUNTER\naborted 2\n# TYPE size COUNTER\nsize 2\n")),
+			},
+			opts: mergeOptions{onConflict: conflictPolicySkipFamily},
+			want: &mergedInputs{
+				names: []string{"a.txt", "b.txt"},
+				families: []*dto.MetricFamily{
+					{
+						Name: newString("aborted"),
+						Type: dto.MetricType_COUNTER.Enum(),
+						Metric: []*dto.Metric{
+							{Counter: &dto.Counter{Value: newFloat64(2)}},
+						},
+					},
+					{
+						Name: newString("size"),
+						Type: dto.MetricType_GAUGE.Enum(),
+						Metric: []*dto.Metric{
+							{Gauge: &dto.Gauge{Value: newFloat64(1)}},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "family filter and relabel",
+			inputs: []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("a.txt",
+					"# TYPE size GAUGE\nsize{dev=\"a\"} 1\nsize{dev=\"b\"} 2\n# TYPE other GAUGE\nother 3\n")),
+			},
+			opts: mergeOptions{
+				familyFilter: func(name string) bool {
+					return name != "other"
+				},
+				relabel: []*relabelRule{
+					{
+						action:       relabelDrop,
+						sourceLabels: []string{"dev"},
+						separator:    ";",
+						regex:        regexp.MustCompile("^(?:b)$"),
+					},
+				},
+			},
+			want: &mergedInputs{
+				names: []string{"a.txt"},
+				families: []*dto.MetricFamily{
+					{
+						Name: newString("size"),
+						Type: dto.MetricType_GAUGE.Enum(),
+						Metric: []*dto.Metric{
+							{
+								Label: []*dto.LabelPair{newLabelPair("dev", "a")},
+								Gauge: &dto.Gauge{Value: newFloat64(1)},
+							},
+						},
+					},
+				},
+			},
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
 			t.Cleanup(cancel)
 
-			got, err := readAndMerge(ctx, tc.inputs)
+			got, err := readAndMerge(ctx, tc.inputs, tc.opts)
 
 			if tc.wantErr != nil {
 				if err == nil || !tc.wantErr.MatchString(err.Error()) {
@@ -293,9 +503,13 @@ func TestReadAndMerge(t *testing.T) {
 			}
 
 			if err == nil {
-				if diff := cmp.Diff(got, tc.want, protocmp.Transform(), cmp.AllowUnexported(mergedInputs{}), cmpopts.EquateEmpty(), cmpopts.EquateApprox(0, 0.0001)); diff != "" {
+				if diff := cmp.Diff(got, tc.want, protocmp.Transform(), cmp.AllowUnexported(mergedInputs{}), cmpopts.IgnoreFields(mergedInputs{}, "failures", "stats"), cmpopts.EquateEmpty(), cmpopts.EquateApprox(0, 0.0001)); diff != "" {
 					t.Errorf("readAndMerge() difference (-got +want):\n%s", diff)
 				}
+
+				if len(got.failures) != tc.wantFailures {
+					t.Errorf("readAndMerge() reported failures %q, want %d", got.failures, tc.wantFailures)
+				}
 			}
 		})
 	}
@@ -316,7 +530,7 @@ func TestReadAndMergeMany(t *testing.T) {
 			fmt.Sprintf("# TYPE test%[1]d GAUGE\ntest%[1]d %[1]d\n", i))))
 	}
 
-	got, err := readAndMerge(ctx, inputs)
+	got, err := readAndMerge(ctx, inputs, mergeOptions{})
 
 	if err != nil {
 		t.Errorf("readAndMerge() failed: %v", err)
@@ -330,3 +544,130 @@ func TestReadAndMergeMany(t *testing.T) {
 		t.Errorf("Result contained %d families, not %d: %v", len(got.families), count, got)
 	}
 }
+
+func TestReadAndMergeCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	t.Cleanup(cancel)
+
+	// Nothing is ever written to the pipe
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+
+	inputs := []inputWrapper{
+		newReaderInputWrapper(newFakeReaderWithName("a.txt", "size 1\n")),
+		&readerInputWrapper{name: "blocked", r: pr},
+	}
+
+	_, err := readAndMerge(ctx, inputs, mergeOptions{})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("readAndMerge() failed with %v, want deadline exceeded", err)
+	}
+}
+
+func TestReadAndMergeStale(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	fresh := filepath.Join(tmpdir, "fresh.prom")
+	stale := filepath.Join(tmpdir, "stale.prom")
+
+	for _, path := range []string{fresh, stale} {
+		if err := os.WriteFile(path, []byte("up 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := time.Unix(1600000000, 0)
+
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatalf("Chtimes() failed: %v", err)
+	}
+
+	inputs := inputWrappersFromPaths([]string{fresh, stale}, inputOptions{maxAge: time.Hour})
+
+	got, err := readAndMerge(context.Background(), inputs, mergeOptions{inputMetrics: true, selfMetrics: true})
+	if err != nil {
+		t.Fatalf("readAndMerge() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(got.names, []string{fresh}); diff != "" {
+		t.Errorf("Input names difference (-got +want):\n%s", diff)
+	}
+
+	if got.stats.inputs != 1 || got.stats.skippedInputs != 0 {
+		t.Errorf("Got %d inputs and %d skipped inputs, want 1 and 0", got.stats.inputs, got.stats.skippedInputs)
+	}
+
+	var buf strings.Builder
+
+	if err := got.write(&buf, false); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	for _, want := range []string{
+		`textformat_merge_input_last_modified_timestamp_seconds{path="` + stale + `"} 1.6e+09`,
+		`textformat_merge_input_parse_success{path="` + fresh + `"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want+"\n") {
+			t.Errorf("Output doesn't contain %q:\n%s", want, buf.String())
+		}
+	}
+
+	if strings.Contains(buf.String(), `textformat_merge_input_parse_success{path="`+stale+`"}`) {
+		t.Errorf("Stale input reported as parsed:\n%s", buf.String())
+	}
+}
+
+func TestReadAndMergeStats(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	inputs := []inputWrapper{
+		newReaderInputWrapper(newFakeReaderWithName("a.txt",
+			"# HELP size aaa\n# TYPE size gauge\nsize{a=\"1\"} 1\n")),
+		newReaderInputWrapper(newFakeReaderWithName("b.txt",
+			"# HELP size bbb\n# TYPE size gauge\nsize{a=\"2\"} 2\nother 3\n")),
+		newReaderInputWrapper(newFakeReaderWithName("bad.txt", "x y z\n")),
+	}
+
+	got, err := readAndMerge(ctx, inputs, mergeOptions{keepGoing: true, selfMetrics: true})
+	if err != nil {
+		t.Fatalf("readAndMerge() failed: %v", err)
+	}
+
+	want := mergeStats{
+		inputs:        2,
+		skippedInputs: 1,
+		bytesRead:     104,
+		families:      2,
+		series:        3,
+		helpConflicts: 1,
+	}
+
+	if diff := cmp.Diff(got.stats, want, cmp.AllowUnexported(mergeStats{}), cmpopts.IgnoreFields(mergeStats{}, "duration")); diff != "" {
+		t.Errorf("readAndMerge() stats difference (-got +want):\n%s", diff)
+	}
+
+	var names []string
+
+	for _, mf := range got.families {
+		names = append(names, mf.GetName())
+	}
+
+	wantNames := []string{
+		"other",
+		"size",
+		"textformat_merge_duration_seconds",
+		"textformat_merge_families",
+		"textformat_merge_help_conflicts",
+		"textformat_merge_inputs",
+		"textformat_merge_inputs_skipped",
+		"textformat_merge_read_bytes",
+		"textformat_merge_series",
+		"textformat_merge_series_truncated",
+	}
+
+	if diff := cmp.Diff(names, wantNames); diff != "" {
+		t.Errorf("Family names difference (-got +want):\n%s", diff)
+	}
+}
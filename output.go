@@ -3,17 +3,187 @@
 package main
 
 import (
-	"bytes"
+	"bufio"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
 
 	"github.com/google/renameio/v2"
 )
 
-func withFileOutput(path string, fn writeFunc) error {
-	var buf bytes.Buffer
+// lookupID resolves a user or group name to its numeric ID. Numeric values are
+// used as-is. An empty name returns -1, i.e. no change.
+func lookupID(name string, lookup func(string) (string, error)) (int, error) {
+	if name == "" {
+		return -1, nil
+	}
+
+	if id, err := strconv.Atoi(name); err == nil && id >= 0 {
+		return id, nil
+	}
+
+	id, err := lookup(name)
+	if err != nil {
+		return -1, err
+	}
+
+	return strconv.Atoi(id)
+}
+
+// chownFile changes the owner and group of a file given as names or IDs.
+func chownFile(fh *os.File, owner, group string) error {
+	uid, err := lookupID(owner, func(name string) (string, error) {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return "", err
+		}
+
+		return u.Uid, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	gid, err := lookupID(group, func(name string) (string, error) {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return "", err
+		}
+
+		return g.Gid, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+
+	return fh.Chown(uid, gid)
+}
+
+// syncDir flushes directory entries, e.g. a rename, to stable storage.
+func syncDir(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	if err := fh.Sync(); err != nil {
+		return err
+	}
+
+	return fh.Close()
+}
 
-	if err := fn(&buf); err != nil {
+// replaceWithoutSync closes the pending file and renames it to the given path
+// without flushing its content to stable storage first.
+func replaceWithoutSync(pending *renameio.PendingFile, path string) error {
+	if err := pending.File.Close(); err != nil {
 		return err
 	}
 
-	return renameio.WriteFile(path, buf.Bytes(), 0o644)
+	return os.Rename(pending.Name(), path)
+}
+
+// pendingFileUnchanged reports whether the content written to the pending file
+// is the same as the content of the file at the given path.
+func pendingFileUnchanged(pending *renameio.PendingFile, path string) (bool, error) {
+	fi, err := pending.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := pending.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	return fileMatches(path, bufio.NewReader(pending), fi.Size())
+}
+
+// applyFileOptions changes the mode, owner and group of an existing file
+// according to the output options.
+func applyFileOptions(path string, opts outputOptions) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	if opts.mode != 0 {
+		if err := fh.Chmod(opts.mode); err != nil {
+			return err
+		}
+	}
+
+	if err := chownFile(fh, opts.owner, opts.group); err != nil {
+		return err
+	}
+
+	return fh.Close()
+}
+
+// withFileOutput writes directly to a temporary file which atomically replaces
+// the destination on success.
+func withFileOutput(path string, opts outputOptions, fn writeFunc) error {
+	var pendingOpts []renameio.Option
+
+	if opts.mode != 0 {
+		pendingOpts = append(pendingOpts, renameio.WithStaticPermissions(opts.mode))
+	} else {
+		pendingOpts = append(pendingOpts,
+			renameio.WithPermissions(0o644),
+			renameio.WithExistingPermissions())
+	}
+
+	pending, err := renameio.NewPendingFile(path, pendingOpts...)
+	if err != nil {
+		return err
+	}
+
+	defer pending.Cleanup()
+
+	if err := chownFile(pending.File, opts.owner, opts.group); err != nil {
+		return err
+	}
+
+	bw := bufio.NewWriter(pending)
+
+	if err := fn(bw); err != nil {
+		return err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return err
+	}
+
+	if opts.onlyIfChanged {
+		if unchanged, err := pendingFileUnchanged(pending, path); err != nil {
+			return err
+		} else if unchanged {
+			// The content is kept, but the file may still need a
+			// different mode or owner
+			return applyFileOptions(path, opts)
+		}
+	}
+
+	if opts.skipFileSync {
+		if err := replaceWithoutSync(pending, path); err != nil {
+			return err
+		}
+	} else if err := pending.CloseAtomicallyReplace(); err != nil {
+		return err
+	}
+
+	if opts.syncDir {
+		return syncDir(filepath.Dir(path))
+	}
+
+	return nil
 }
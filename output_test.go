@@ -1,13 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 )
@@ -68,7 +71,7 @@ func TestWithOutput(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			stdout := withReplacedStdoutWriter(t)
 
-			err := withOutput(tc.path, tc.fn)
+			err := withOutput(tc.path, outputOptions{}, tc.fn)
 
 			if tc.checkErr != nil {
 				tc.checkErr(t, err)
@@ -113,7 +116,7 @@ func TestWithFileOutputPreserveMode(t *testing.T) {
 				fh.Close()
 			}
 
-			if err := withFileOutput(path, func(w io.Writer) error {
+			if err := withFileOutput(path, outputOptions{}, func(w io.Writer) error {
 				io.WriteString(w, "content\n")
 				return nil
 			}); err != nil {
@@ -131,3 +134,245 @@ func TestWithFileOutputPreserveMode(t *testing.T) {
 		})
 	}
 }
+
+func TestFileHasContent(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	path := filepath.Join(tmpdir, "test.txt")
+	large := strings.Repeat("0123456789abcdef", 10000)
+
+	if err := os.WriteFile(path, []byte(large), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		content string
+		want    bool
+	}{
+		{name: "missing", path: filepath.Join(tmpdir, "missing.txt")},
+		{name: "directory", path: tmpdir},
+		{name: "same", path: path, content: large, want: true},
+		{name: "shorter", path: path, content: large[1:]},
+		{name: "different", path: path, content: large[:len(large)-1] + "x"},
+		{name: "different start", path: path, content: "x" + large[1:]},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := fileHasContent(tc.path, []byte(tc.content))
+
+			if err != nil {
+				t.Errorf("fileHasContent() failed: %v", err)
+			} else if got != tc.want {
+				t.Errorf("fileHasContent() returned %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestWithFileOutputOnlyIfChanged(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(path, []byte("content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := outputOptions{onlyIfChanged: true}
+
+	for _, tc := range []struct {
+		content     string
+		wantModTime bool
+	}{
+		{content: "content\n", wantModTime: true},
+		{content: "changed\n"},
+	} {
+		if err := withFileOutput(path, opts, func(w io.Writer) error {
+			io.WriteString(w, tc.content)
+			return nil
+		}); err != nil {
+			t.Errorf("withFileOutput() failed: %v", err)
+		}
+
+		if fi, err := os.Stat(path); err != nil {
+			t.Errorf("Stat() failed: %v", err)
+		} else if got := fi.ModTime().Equal(old); got != tc.wantModTime {
+			t.Errorf("Modification time %v, preserved %v, want %v", fi.ModTime(), got, tc.wantModTime)
+		}
+
+		if got, err := os.ReadFile(path); err != nil {
+			t.Errorf("ReadFile() failed: %v", err)
+		} else if diff := cmp.Diff(string(got), tc.content); diff != "" {
+			t.Errorf("File content difference (-got +want):\n%s", diff)
+		}
+	}
+}
+
+func TestWithFileOutputOnlyIfChangedOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File modes and ownership are not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(path, []byte("content\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := outputOptions{
+		onlyIfChanged: true,
+		mode:          0o600,
+		owner:         strconv.Itoa(os.Getuid()),
+		group:         strconv.Itoa(os.Getgid()),
+	}
+
+	if err := withFileOutput(path, opts, func(w io.Writer) error {
+		io.WriteString(w, "content\n")
+		return nil
+	}); err != nil {
+		t.Errorf("withFileOutput() failed: %v", err)
+	}
+
+	if fi, err := os.Stat(path); err != nil {
+		t.Errorf("Stat() failed: %v", err)
+	} else if got := fi.Mode().Perm(); got != opts.mode {
+		t.Errorf("Got file mode %04o, want %04o", got, opts.mode)
+	}
+}
+
+func TestWithFileOutputFailure(t *testing.T) {
+	tmpdir := t.TempDir()
+	path := filepath.Join(tmpdir, "test.txt")
+
+	if err := os.WriteFile(path, []byte("good\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	errTest := errors.New("test")
+
+	if err := withFileOutput(path, outputOptions{}, func(w io.Writer) error {
+		io.WriteString(w, "partial")
+		return errTest
+	}); !errors.Is(err, errTest) {
+		t.Errorf("withFileOutput() failed with %v, want %v", err, errTest)
+	}
+
+	if got, err := os.ReadFile(path); err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	} else if diff := cmp.Diff(string(got), "good\n"); diff != "" {
+		t.Errorf("File content difference (-got +want):\n%s", diff)
+	}
+
+	if entries, err := os.ReadDir(tmpdir); err != nil {
+		t.Error(err)
+	} else if len(entries) != 1 {
+		t.Errorf("Temporary file not removed: %v", entries)
+	}
+}
+
+func TestWithFileOutputSkipFileSync(t *testing.T) {
+	tmpdir := t.TempDir()
+	path := filepath.Join(tmpdir, "test.txt")
+
+	if err := os.WriteFile(path, []byte("old\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := withFileOutput(path, outputOptions{skipFileSync: true}, func(w io.Writer) error {
+		io.WriteString(w, "content\n")
+		return nil
+	}); err != nil {
+		t.Errorf("withFileOutput() failed: %v", err)
+	}
+
+	if got, err := os.ReadFile(path); err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	} else if diff := cmp.Diff(string(got), "content\n"); diff != "" {
+		t.Errorf("File content difference (-got +want):\n%s", diff)
+	}
+
+	if entries, err := os.ReadDir(tmpdir); err != nil {
+		t.Error(err)
+	} else if len(entries) != 1 {
+		t.Errorf("Temporary file not removed: %v", entries)
+	}
+}
+
+func TestWithFileOutputOptions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("File modes and ownership are not supported on Windows")
+	}
+
+	path := filepath.Join(t.TempDir(), "test.txt")
+
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := outputOptions{
+		mode:    0o640,
+		owner:   strconv.Itoa(os.Getuid()),
+		group:   strconv.Itoa(os.Getgid()),
+		syncDir: true,
+	}
+
+	if err := withFileOutput(path, opts, func(w io.Writer) error {
+		io.WriteString(w, "content\n")
+		return nil
+	}); err != nil {
+		t.Errorf("withFileOutput() failed: %v", err)
+	}
+
+	if fi, err := os.Stat(path); err != nil {
+		t.Errorf("Stat() failed: %v", err)
+	} else if got := fi.Mode().Perm(); got != opts.mode {
+		t.Errorf("Got file mode %04o, want %04o", got, opts.mode)
+	}
+
+	opts = outputOptions{owner: "nonexistent-user-for-test"}
+
+	if err := withFileOutput(path, opts, func(w io.Writer) error {
+		return nil
+	}); err == nil {
+		t.Errorf("withFileOutput() succeeded with unknown owner")
+	}
+
+	if got, err := os.ReadFile(path); err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	} else if diff := cmp.Diff(string(got), "content\n"); diff != "" {
+		t.Errorf("File content difference (-got +want):\n%s", diff)
+	}
+}
+
+func TestFileModeFlag(t *testing.T) {
+	for _, tc := range []struct {
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{value: "644", want: 0o644},
+		{value: "0640", want: 0o640},
+		{value: "0", wantErr: true},
+		{value: "1777", wantErr: true},
+		{value: "rw-r--r--", wantErr: true},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			var got fileModeFlag
+
+			if err := got.Set(tc.value); err != nil {
+				if !tc.wantErr {
+					t.Errorf("Set() failed: %v", err)
+				}
+			} else if tc.wantErr {
+				t.Errorf("Set() succeeded, want error")
+			} else if os.FileMode(got) != tc.want {
+				t.Errorf("Set() returned %04o, want %04o", got, tc.want)
+			}
+		})
+	}
+}
@@ -2,15 +2,22 @@ package main
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"os"
 )
 
-func withFileOutput(path string, fn writeFunc) error {
+func withFileOutput(path string, opts outputOptions, fn writeFunc) error {
+	if opts.owner != "" || opts.group != "" {
+		return errors.New("changing the owner or group is not supported on Windows")
+	}
+
 	var mode os.FileMode = 0644
 
-	// Try to re-use mode from an existing file
-	if fi, err := os.Stat(path); err == nil {
+	if opts.mode != 0 {
+		mode = opts.mode
+	} else if fi, err := os.Stat(path); err == nil {
+		// Re-use mode from an existing file
 		mode = fi.Mode().Perm()
 	} else if !os.IsNotExist(err) {
 		return err
@@ -22,6 +29,18 @@ func withFileOutput(path string, fn writeFunc) error {
 		return err
 	}
 
+	if opts.onlyIfChanged {
+		if unchanged, err := fileHasContent(path, buf.Bytes()); err != nil {
+			return err
+		} else if unchanged {
+			if opts.mode != 0 {
+				return os.Chmod(path, opts.mode)
+			}
+
+			return nil
+		}
+	}
+
 	// As of August 2021 the github.com/google/renameio package does not
 	// support Windows and falls back to using ioutil.WriteFile.
 	return ioutil.WriteFile(path, buf.Bytes(), mode)
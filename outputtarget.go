@@ -0,0 +1,175 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+type outputFormat string
+
+const (
+	outputFormatText        outputFormat = "text"
+	outputFormatOpenMetrics outputFormat = "openmetrics"
+)
+
+// outputTarget is a destination for merged metrics.
+type outputTarget struct {
+	// File path, empty for standard output.
+	path   string
+	format outputFormat
+
+	// Only families with matching names are written if set.
+	include *regexp.Regexp
+
+	// Families with matching names are not written if set.
+	exclude *regexp.Regexp
+
+	opts outputOptions
+}
+
+func (t outputTarget) keep(name string) bool {
+	if t.include != nil && !t.include.MatchString(name) {
+		return false
+	}
+
+	return t.exclude == nil || !t.exclude.MatchString(name)
+}
+
+// write renders the merged metrics accepted by the filters.
+func (t outputTarget) write(w io.Writer, merged *mergedInputs, showInputs bool) error {
+	view := merged.filtered(t.keep)
+
+	if t.format == outputFormatOpenMetrics {
+		return view.encode(w, expfmt.NewFormat(expfmt.TypeOpenMetrics))
+	}
+
+	return view.write(w, showInputs)
+}
+
+// splitOutputTarget splits "key=value" pairs separated by commas. Commas in
+// regular expressions are part of the value unless followed by a known key.
+func splitOutputTarget(value string) []string {
+	var result []string
+	var inRegexp bool
+
+	for _, i := range strings.Split(value, ",") {
+		key, _, _ := strings.Cut(i, "=")
+
+		switch key {
+		case "path", "format":
+			inRegexp = false
+
+		case "include", "exclude":
+			inRegexp = true
+
+		default:
+			if inRegexp {
+				result[len(result)-1] += "," + i
+				continue
+			}
+		}
+
+		result = append(result, i)
+	}
+
+	return result
+}
+
+func parseOutputTarget(value string) (outputTarget, error) {
+	result := outputTarget{
+		format: outputFormatText,
+	}
+
+	var hasPath bool
+
+	for _, i := range splitOutputTarget(value) {
+		key, value, _ := strings.Cut(i, "=")
+
+		switch key {
+		case "path":
+			hasPath = true
+
+			if value != stdinPlaceholder {
+				result.path = value
+			}
+
+		case "format":
+			switch f := outputFormat(value); f {
+			case outputFormatText, outputFormatOpenMetrics:
+				result.format = f
+			default:
+				return outputTarget{}, fmt.Errorf("unknown output format %q", value)
+			}
+
+		case "include", "exclude":
+			re, err := regexp.Compile("^(?:" + value + ")$")
+			if err != nil {
+				return outputTarget{}, fmt.Errorf("%s: %w", key, err)
+			}
+
+			if key == "include" {
+				result.include = re
+			} else {
+				result.exclude = re
+			}
+
+		default:
+			return outputTarget{}, fmt.Errorf("unknown output target option %q", i)
+		}
+	}
+
+	if !hasPath {
+		return outputTarget{}, fmt.Errorf("output target %q without path", value)
+	}
+
+	return result, nil
+}
+
+// outputTargetsFlag collects output targets given as
+// "path=<file>[,format=<format>][,include=<regexp>][,exclude=<regexp>]".
+type outputTargetsFlag []outputTarget
+
+var _ flag.Value = (*outputTargetsFlag)(nil)
+
+func (f *outputTargetsFlag) String() string {
+	return ""
+}
+
+func (f *outputTargetsFlag) Set(value string) error {
+	target, err := parseOutputTarget(value)
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, target)
+
+	return nil
+}
+
+// outputs returns all destinations. Standard output is used if neither
+// --output nor --output-target are given.
+func (f *cliFlags) outputs() []outputTarget {
+	var result []outputTarget
+
+	opts := f.outputOptions()
+
+	if f.outputFile != "" || len(f.outputTargets) == 0 {
+		result = append(result, outputTarget{
+			path:   f.outputFile,
+			format: outputFormatText,
+			opts:   opts,
+		})
+	}
+
+	for _, i := range f.outputTargets {
+		i.opts = opts
+		result = append(result, i)
+	}
+
+	return result
+}
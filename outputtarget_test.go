@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseOutputTarget(t *testing.T) {
+	for _, tc := range []struct {
+		value       string
+		wantPath    string
+		wantFormat  outputFormat
+		wantInclude string
+		wantExclude string
+		wantErr     *regexp.Regexp
+	}{
+		{value: "path=out.prom", wantPath: "out.prom", wantFormat: outputFormatText},
+		{value: "path=-,format=openmetrics", wantFormat: outputFormatOpenMetrics},
+		{
+			value:       "format=text,path=a.prom,include=node_.*,exclude=node_(a|b){1,2}",
+			wantPath:    "a.prom",
+			wantFormat:  outputFormatText,
+			wantInclude: `^(?:node_.*)$`,
+			wantExclude: `^(?:node_(a|b){1,2})$`,
+		},
+		{value: "", wantErr: regexp.MustCompile(`^unknown output target option ""$`)},
+		{value: "format=text", wantErr: regexp.MustCompile(`without path$`)},
+		{value: "path=a,format=json", wantErr: regexp.MustCompile(`^unknown output format "json"$`)},
+		{value: "path=a,include=(", wantErr: regexp.MustCompile(`^include: error parsing regexp`)},
+		{value: "path=a,mode=0600", wantErr: regexp.MustCompile(`^unknown output target option "mode=0600"$`)},
+	} {
+		t.Run(tc.value, func(t *testing.T) {
+			got, err := parseOutputTarget(tc.value)
+
+			if tc.wantErr != nil {
+				if err == nil || !tc.wantErr.MatchString(err.Error()) {
+					t.Errorf("parseOutputTarget() failed with %v, want match for %q", err, tc.wantErr.String())
+				}
+
+				return
+			} else if err != nil {
+				t.Fatalf("parseOutputTarget() failed: %v", err)
+			}
+
+			if got.path != tc.wantPath || got.format != tc.wantFormat {
+				t.Errorf("parseOutputTarget() returned path %q and format %q, want %q and %q", got.path, got.format, tc.wantPath, tc.wantFormat)
+			}
+
+			for _, i := range []struct {
+				re   *regexp.Regexp
+				want string
+			}{
+				{got.include, tc.wantInclude},
+				{got.exclude, tc.wantExclude},
+			} {
+				if i.re == nil {
+					if i.want != "" {
+						t.Errorf("Missing regexp, want %q", i.want)
+					}
+				} else if i.re.String() != i.want {
+					t.Errorf("Got regexp %q, want %q", i.re.String(), i.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOutputTargetWrite(t *testing.T) {
+	inputs := func() []inputWrapper {
+		return []inputWrapper{
+			newReaderInputWrapper(newFakeReaderWithName("a.txt",
+				"# TYPE node_load1 gauge\nnode_load1 1\n# TYPE requests_total counter\nrequests_total 5\n")),
+			newReaderInputWrapper(newFakeReaderWithName("b.txt",
+				"# TYPE node_load5 gauge\nnode_load5 2\n# TYPE requests_total counter\nrequests_total{code=\"500\"} 1\n")),
+		}
+	}
+
+	for _, tc := range []struct {
+		name   string
+		target string
+		want   string
+	}{
+		{
+			name:   "all",
+			target: "path=-",
+			want: `# TYPE node_load1 gauge
+node_load1 1
+# TYPE node_load5 gauge
+node_load5 2
+# TYPE requests_total counter
+requests_total 5
+requests_total{code="500"} 1
+`,
+		},
+		{
+			name:   "include",
+			target: "path=-,include=node_.*,exclude=node_load5",
+			want:   "# TYPE node_load1 gauge\nnode_load1 1\n",
+		},
+		{
+			name:   "openmetrics",
+			target: "path=-,format=openmetrics,include=requests_total",
+			want: `# TYPE requests counter
+requests_total 5.0
+requests_total{code="500"} 1.0
+# EOF
+`,
+		},
+	} {
+		for _, spillThreshold := range []int64{0, 1} {
+			t.Run(tc.name, func(t *testing.T) {
+				target, err := parseOutputTarget(tc.target)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				merged, err := readAndMerge(context.Background(), inputs(), mergeOptions{
+					spillThreshold: spillThreshold,
+					spillDir:       t.TempDir(),
+				})
+				if err != nil {
+					t.Fatalf("readAndMerge() failed: %v", err)
+				}
+
+				defer merged.close()
+
+				var buf strings.Builder
+
+				if err := target.write(&buf, merged, false); err != nil {
+					t.Errorf("write() failed: %v", err)
+				}
+
+				if diff := cmp.Diff(buf.String(), tc.want); diff != "" {
+					t.Errorf("Output difference (-got +want):\n%s", diff)
+				}
+			})
+		}
+	}
+}
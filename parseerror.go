@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+const (
+	// The text parser reads ahead through a buffer of a few kilobytes. Enough
+	// recent lines are retained to cover the buffer.
+	lineRecorderRetainBytes = 64 * 1024
+
+	// Retained lines are truncated to this length.
+	lineRecorderMaxLineLength = 1024
+)
+
+type recordedLine struct {
+	number  int
+	content string
+}
+
+// lineRecorder passes data through while retaining the most recently read
+// lines. It's used to show the offending line of parse errors.
+type lineRecorder struct {
+	r io.Reader
+
+	lines         []recordedLine
+	retainedBytes int
+
+	// Number and content of the line not yet terminated by a newline.
+	current    int
+	currentBuf bytes.Buffer
+}
+
+func newLineRecorder(r io.Reader) *lineRecorder {
+	return &lineRecorder{
+		r:       r,
+		current: 1,
+	}
+}
+
+func (r *lineRecorder) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+
+	for buf := p[:n]; len(buf) > 0; {
+		pos := bytes.IndexByte(buf, '\n')
+		if pos < 0 {
+			r.appendCurrent(buf)
+			break
+		}
+
+		r.appendCurrent(buf[:pos])
+		r.finishLine()
+
+		buf = buf[pos+1:]
+	}
+
+	return n, err
+}
+
+func (r *lineRecorder) appendCurrent(buf []byte) {
+	if avail := lineRecorderMaxLineLength - r.currentBuf.Len(); avail < len(buf) {
+		buf = buf[:max(0, avail)]
+	}
+
+	r.currentBuf.Write(buf)
+}
+
+func (r *lineRecorder) finishLine() {
+	line := recordedLine{
+		number:  r.current,
+		content: r.currentBuf.String(),
+	}
+
+	r.lines = append(r.lines, line)
+	r.retainedBytes += len(line.content)
+
+	for len(r.lines) > 1 && r.retainedBytes > lineRecorderRetainBytes {
+		r.retainedBytes -= len(r.lines[0].content)
+		r.lines = r.lines[1:]
+	}
+
+	r.current++
+	r.currentBuf.Reset()
+}
+
+// line returns the content of the given line if it's still retained.
+func (r *lineRecorder) line(number int) (string, bool) {
+	if number == r.current {
+		return r.currentBuf.String(), r.currentBuf.Len() > 0
+	}
+
+	for _, i := range r.lines {
+		if i.number == number {
+			return i.content, true
+		}
+	}
+
+	return "", false
+}
+
+// Matches strings and characters quoted by the text parser using %q.
+var quotedTokenRe = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)+'`)
+
+// guessColumn returns the 1-based column of the last token quoted in the
+// parser message, or zero if it can't be located in the line.
+func guessColumn(content, msg string) int {
+	quoted := quotedTokenRe.FindAllString(msg, -1)
+	if len(quoted) == 0 {
+		return 0
+	}
+
+	var token string
+
+	last := quoted[len(quoted)-1]
+
+	if last[0] == '\'' {
+		value, _, _, err := strconv.UnquoteChar(last[1:len(last)-1], '\'')
+		if err != nil {
+			return 0
+		}
+
+		token = string(value)
+	} else {
+		var err error
+
+		if token, err = strconv.Unquote(last); err != nil {
+			return 0
+		}
+	}
+
+	if token == "" || strings.TrimSpace(token) == "" {
+		return 0
+	}
+
+	if pos := strings.LastIndex(content, token); pos >= 0 {
+		return pos + 1
+	}
+
+	return 0
+}
+
+// parseError describes a parser failure including the offending line, if
+// known.
+type parseError struct {
+	input string
+	err   expfmt.ParseError
+
+	// 1-based column, zero if unknown.
+	column int
+
+	// Content of the offending line, empty if not retained.
+	content string
+}
+
+func (r *lineRecorder) parseError(input string, err expfmt.ParseError) *parseError {
+	result := &parseError{
+		input: input,
+		err:   err,
+	}
+
+	if content, ok := r.line(err.Line); ok {
+		result.content = content
+		result.column = guessColumn(content, err.Msg)
+	}
+
+	return result
+}
+
+// position formats the location as "input:line[:column]".
+func (e *parseError) position() string {
+	pos := fmt.Sprintf("%s:%d", e.input, e.err.Line)
+
+	if e.column > 0 {
+		pos += fmt.Sprintf(":%d", e.column)
+	}
+
+	return pos
+}
+
+// Error formats the error as "input:line[:column]: message", followed by the
+// offending line and a caret pointing to the column, if known.
+func (e *parseError) Error() string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%s: %s", e.position(), e.err.Msg)
+
+	if e.content != "" {
+		fmt.Fprintf(&sb, "\n\t%s", e.content)
+
+		if e.column > 0 {
+			sb.WriteString("\n\t")
+
+			// Keep tabs to align the caret with the content.
+			for _, c := range e.content[:e.column-1] {
+				if c == '\t' {
+					sb.WriteRune('\t')
+				} else {
+					sb.WriteRune(' ')
+				}
+			}
+
+			sb.WriteRune('^')
+		}
+	}
+
+	return sb.String()
+}
+
+func (e *parseError) Unwrap() error {
+	return e.err
+}
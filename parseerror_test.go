@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+)
+
+func TestLineRecorder(t *testing.T) {
+	var content strings.Builder
+
+	for i := 1; i <= 10000; i++ {
+		fmt.Fprintf(&content, "line%d\n", i)
+	}
+
+	content.WriteString("partial")
+
+	r := newLineRecorder(iotest.HalfReader(strings.NewReader(content.String())))
+
+	if got, err := io.ReadAll(r); err != nil {
+		t.Errorf("ReadAll() failed: %v", err)
+	} else if diff := cmp.Diff(string(got), content.String()); diff != "" {
+		t.Errorf("Content difference (-got +want):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		number int
+		want   string
+		wantOk bool
+	}{
+		{number: 1},
+		{number: 9999, want: "line9999", wantOk: true},
+		{number: 10000, want: "line10000", wantOk: true},
+		{number: 10001, want: "partial", wantOk: true},
+		{number: 10002},
+	} {
+		got, ok := r.line(tc.number)
+
+		if ok != tc.wantOk || got != tc.want {
+			t.Errorf("line(%d) returned (%q, %v), want (%q, %v)", tc.number, got, ok, tc.want, tc.wantOk)
+		}
+	}
+}
+
+func TestGuessColumn(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		msg     string
+		want    int
+	}{
+		{name: "empty"},
+		{
+			name:    "no quotes",
+			content: "foo bar",
+			msg:     "invalid metric name",
+		},
+		{
+			name:    "string",
+			content: "foo bar",
+			msg:     `expected float as value, got "bar"`,
+			want:    5,
+		},
+		{
+			name:    "character",
+			content: `foo{a"b"} 1`,
+			msg:     `expected '=' after label name, found '"'`,
+			want:    8,
+		},
+		{
+			name:    "not found",
+			content: "foo bar",
+			msg:     `expected float as value, got "baz"`,
+		},
+		{
+			name:    "whitespace",
+			content: "foo bar",
+			msg:     `expected float as value, got " "`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := guessColumn(tc.content, tc.msg); got != tc.want {
+				t.Errorf("guessColumn(%q, %q) returned %d, want %d", tc.content, tc.msg, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseErrorFormat(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{
+			name:    "value",
+			content: "# TYPE foo gauge\nfoo\t1\nbar abc\n",
+			want:    "input.prom:3:5: expected float as value, got \"abc\"\n\tbar abc\n\t    ^",
+		},
+		{
+			name:    "tab",
+			content: "bar\tabc\n",
+			want:    "input.prom:1:5: expected float as value, got \"abc\"\n\tbar\tabc\n\t   \t^",
+		},
+		{
+			name:    "metric name",
+			content: "# TYPE foo gauge\n# TYPE foo gauge\n",
+			want:    "input.prom:2:8: second TYPE line for metric name \"foo\", or TYPE reported after samples\n\t# TYPE foo gauge\n\t       ^",
+		},
+		{
+			name:    "without column",
+			content: "# TYPE 0foo gauge\n",
+			want:    "input.prom:1: invalid metric name in comment\n\t# TYPE 0foo gauge",
+		},
+		{
+			name:    "end of input",
+			content: "foo",
+			want:    "input.prom:1: unexpected end of input stream\n\tfoo",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newLineRecorder(strings.NewReader(tc.content))
+
+			parser := expfmt.NewTextParser(model.UTF8Validation)
+
+			_, err := parser.TextToMetricFamilies(r)
+
+			var pe expfmt.ParseError
+
+			if !errors.As(err, &pe) {
+				t.Fatalf("TextToMetricFamilies() returned %v, want ParseError", err)
+			}
+
+			got := r.parseError("input.prom", pe)
+
+			if diff := cmp.Diff(got.Error(), tc.want); diff != "" {
+				t.Errorf("Error() difference (-got +want):\n%s", diff)
+			}
+
+			if !errors.Is(got, pe) {
+				t.Errorf("Error %v doesn't wrap %v", got, pe)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// relabelAction names the operation of a relabel rule. The semantics follow
+// the Prometheus relabelling configuration.
+type relabelAction string
+
+const (
+	relabelReplace   relabelAction = "replace"
+	relabelKeep      relabelAction = "keep"
+	relabelDrop      relabelAction = "drop"
+	relabelLabelDrop relabelAction = "labeldrop"
+	relabelLabelKeep relabelAction = "labelkeep"
+)
+
+// relabelRule modifies the labels of series or drops series altogether. The
+// metric name is available as the "__name__" source label, but can't be
+// changed.
+type relabelRule struct {
+	action       relabelAction
+	sourceLabels []string
+	separator    string
+	regex        *regexp.Regexp
+	targetLabel  string
+	replacement  string
+}
+
+// value concatenates the values of the source labels.
+func (r *relabelRule) value(name string, labels []*dto.LabelPair) string {
+	values := make([]string, 0, len(r.sourceLabels))
+
+	for _, source := range r.sourceLabels {
+		var value string
+
+		if source == model.MetricNameLabel {
+			value = name
+		} else {
+			for _, lp := range labels {
+				if lp.GetName() == source {
+					value = lp.GetValue()
+					break
+				}
+			}
+		}
+
+		values = append(values, value)
+	}
+
+	return strings.Join(values, r.separator)
+}
+
+// apply returns the labels of a series after applying the rule. The returned
+// boolean is false if the series is to be dropped.
+func (r *relabelRule) apply(name string, labels []*dto.LabelPair) ([]*dto.LabelPair, bool) {
+	switch r.action {
+	case relabelKeep:
+		return labels, r.regex.MatchString(r.value(name, labels))
+
+	case relabelDrop:
+		return labels, !r.regex.MatchString(r.value(name, labels))
+
+	case relabelLabelDrop, relabelLabelKeep:
+		result := make([]*dto.LabelPair, 0, len(labels))
+
+		for _, lp := range labels {
+			if r.regex.MatchString(lp.GetName()) == (r.action == relabelLabelKeep) {
+				result = append(result, lp)
+			}
+		}
+
+		return result, true
+	}
+
+	value := r.value(name, labels)
+
+	match := r.regex.FindStringSubmatchIndex(value)
+	if match == nil {
+		return labels, true
+	}
+
+	target := string(r.regex.ExpandString(nil, r.targetLabel, value, match))
+	replacement := string(r.regex.ExpandString(nil, r.replacement, value, match))
+
+	if !model.LabelName(target).IsValid() || strings.HasPrefix(target, "__") {
+		return labels, true
+	}
+
+	result := make([]*dto.LabelPair, 0, len(labels)+1)
+
+	for _, lp := range labels {
+		if lp.GetName() != target {
+			result = append(result, lp)
+		}
+	}
+
+	if replacement != "" {
+		result = append(result, newLabelPair(target, replacement))
+	}
+
+	return result, true
+}
+
+// relabelFamilies applies all rules to every series. Families without any
+// remaining series are removed.
+func relabelFamilies(families map[string]*dto.MetricFamily, rules []*relabelRule) {
+	if len(rules) == 0 {
+		return
+	}
+
+	for name, mf := range families {
+		kept := mf.Metric[:0]
+
+	METRICS:
+		for _, m := range mf.GetMetric() {
+			labels := m.GetLabel()
+
+			for _, rule := range rules {
+				var keep bool
+
+				if labels, keep = rule.apply(name, labels); !keep {
+					continue METRICS
+				}
+			}
+
+			m.Label = labels
+			kept = append(kept, m)
+		}
+
+		if len(kept) == 0 {
+			delete(families, name)
+		} else {
+			mf.Metric = kept
+		}
+	}
+}
+
+type relabelConfig struct {
+	Action       string   `yaml:"action"`
+	SourceLabels []string `yaml:"source_labels"`
+	Separator    *string  `yaml:"separator"`
+	Regex        *string  `yaml:"regex"`
+	TargetLabel  string   `yaml:"target_label"`
+	Replacement  *string  `yaml:"replacement"`
+}
+
+func newRelabelRule(cfg relabelConfig) (*relabelRule, error) {
+	rule := &relabelRule{
+		action:       relabelAction(cfg.Action),
+		sourceLabels: cfg.SourceLabels,
+		separator:    ";",
+		targetLabel:  cfg.TargetLabel,
+		replacement:  "$1",
+	}
+
+	if rule.action == "" {
+		rule.action = relabelReplace
+	}
+
+	if cfg.Separator != nil {
+		rule.separator = *cfg.Separator
+	}
+
+	if cfg.Replacement != nil {
+		rule.replacement = *cfg.Replacement
+	}
+
+	pattern := "(.*)"
+
+	if cfg.Regex != nil {
+		pattern = *cfg.Regex
+	}
+
+	var err error
+
+	if rule.regex, err = regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+		return nil, fmt.Errorf("regex: %w", err)
+	}
+
+	switch rule.action {
+	case relabelReplace:
+		if rule.targetLabel == "" {
+			return nil, fmt.Errorf("%s action requires target_label", rule.action)
+		}
+
+		if rule.targetLabel == model.MetricNameLabel {
+			return nil, fmt.Errorf("changing %s is not supported", model.MetricNameLabel)
+		}
+
+	case relabelKeep, relabelDrop:
+		if len(rule.sourceLabels) == 0 {
+			return nil, fmt.Errorf("%s action requires source_labels", rule.action)
+		}
+
+	case relabelLabelDrop, relabelLabelKeep:
+
+	default:
+		return nil, fmt.Errorf("unknown action %q", cfg.Action)
+	}
+
+	return rule, nil
+}
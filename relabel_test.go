@@ -0,0 +1,236 @@
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestRelabelRuleApply(t *testing.T) {
+	labels := []*dto.LabelPair{
+		newLabelPair("instance", "host1:9100"),
+		newLabelPair("job", "node"),
+	}
+
+	for _, tc := range []struct {
+		name     string
+		cfg      relabelConfig
+		want     []*dto.LabelPair
+		wantDrop bool
+	}{
+		{
+			name: "replace",
+			cfg: relabelConfig{
+				SourceLabels: []string{"instance"},
+				Regex:        newString("(.*):.*"),
+				TargetLabel:  "host",
+			},
+			want: []*dto.LabelPair{
+				newLabelPair("instance", "host1:9100"),
+				newLabelPair("job", "node"),
+				newLabelPair("host", "host1"),
+			},
+		},
+		{
+			name: "replace existing",
+			cfg: relabelConfig{
+				SourceLabels: []string{"__name__", "job"},
+				Separator:    newString("/"),
+				TargetLabel:  "job",
+			},
+			want: []*dto.LabelPair{
+				newLabelPair("instance", "host1:9100"),
+				newLabelPair("job", "up/node"),
+			},
+		},
+		{
+			name: "replace no match",
+			cfg: relabelConfig{
+				SourceLabels: []string{"job"},
+				Regex:        newString("other"),
+				TargetLabel:  "job",
+				Replacement:  newString("x"),
+			},
+			want: labels,
+		},
+		{
+			name: "replace with empty value removes label",
+			cfg: relabelConfig{
+				SourceLabels: []string{"job"},
+				TargetLabel:  "instance",
+				Replacement:  newString(""),
+			},
+			want: []*dto.LabelPair{
+				newLabelPair("job", "node"),
+			},
+		},
+		{
+			name: "replace static",
+			cfg: relabelConfig{
+				TargetLabel: "env",
+				Replacement: newString("prod"),
+			},
+			want: []*dto.LabelPair{
+				newLabelPair("instance", "host1:9100"),
+				newLabelPair("job", "node"),
+				newLabelPair("env", "prod"),
+			},
+		},
+		{
+			name: "keep",
+			cfg: relabelConfig{
+				Action:       "keep",
+				SourceLabels: []string{"job"},
+				Regex:        newString("node|other"),
+			},
+			want: labels,
+		},
+		{
+			name: "keep no match",
+			cfg: relabelConfig{
+				Action:       "keep",
+				SourceLabels: []string{"job"},
+				Regex:        newString("no"),
+			},
+			wantDrop: true,
+		},
+		{
+			name: "drop",
+			cfg: relabelConfig{
+				Action:       "drop",
+				SourceLabels: []string{"instance"},
+				Regex:        newString("host1:.*"),
+			},
+			wantDrop: true,
+		},
+		{
+			name: "labeldrop",
+			cfg: relabelConfig{
+				Action: "labeldrop",
+				Regex:  newString("inst.*"),
+			},
+			want: []*dto.LabelPair{
+				newLabelPair("job", "node"),
+			},
+		},
+		{
+			name: "labelkeep",
+			cfg: relabelConfig{
+				Action: "labelkeep",
+				Regex:  newString("inst.*"),
+			},
+			want: []*dto.LabelPair{
+				newLabelPair("instance", "host1:9100"),
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rule, err := newRelabelRule(tc.cfg)
+			if err != nil {
+				t.Fatalf("newRelabelRule() failed: %v", err)
+			}
+
+			got, keep := rule.apply("up", labels)
+
+			if keep == tc.wantDrop {
+				t.Errorf("apply() returned keep=%v, want %v", keep, !tc.wantDrop)
+			}
+
+			if !keep {
+				return
+			}
+
+			if diff := cmp.Diff(got, tc.want, protocmp.Transform()); diff != "" {
+				t.Errorf("apply() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNewRelabelRuleErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		cfg     relabelConfig
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:    "unknown action",
+			cfg:     relabelConfig{Action: "hashmod"},
+			wantErr: regexp.MustCompile(`^unknown action "hashmod"$`),
+		},
+		{
+			name:    "bad regex",
+			cfg:     relabelConfig{Action: "labeldrop", Regex: newString("(")},
+			wantErr: regexp.MustCompile(`^regex: `),
+		},
+		{
+			name:    "replace without target",
+			cfg:     relabelConfig{SourceLabels: []string{"job"}},
+			wantErr: regexp.MustCompile(`^replace action requires target_label$`),
+		},
+		{
+			name:    "replace metric name",
+			cfg:     relabelConfig{SourceLabels: []string{"job"}, TargetLabel: "__name__"},
+			wantErr: regexp.MustCompile(`^changing __name__ is not supported$`),
+		},
+		{
+			name:    "keep without source labels",
+			cfg:     relabelConfig{Action: "keep"},
+			wantErr: regexp.MustCompile(`^keep action requires source_labels$`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := newRelabelRule(tc.cfg)
+
+			if err == nil || !tc.wantErr.MatchString(err.Error()) {
+				t.Errorf("newRelabelRule() failed with %v, want match for %q", err, tc.wantErr.String())
+			}
+		})
+	}
+}
+
+func TestRelabelFamilies(t *testing.T) {
+	families := map[string]*dto.MetricFamily{
+		"kept": {
+			Name: newString("kept"),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{newLabelPair("job", "a")}},
+				{Label: []*dto.LabelPair{newLabelPair("job", "b")}},
+			},
+		},
+		"dropped": {
+			Name: newString("dropped"),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{newLabelPair("job", "b")}},
+			},
+		},
+	}
+
+	rule, err := newRelabelRule(relabelConfig{
+		Action:       "drop",
+		SourceLabels: []string{"job"},
+		Regex:        newString("b"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	relabelFamilies(families, []*relabelRule{rule})
+
+	want := map[string]*dto.MetricFamily{
+		"kept": {
+			Name: newString("kept"),
+			Metric: []*dto.Metric{
+				{Label: []*dto.LabelPair{newLabelPair("job", "a")}},
+			},
+		},
+	}
+
+	if diff := cmp.Diff(families, want, protocmp.Transform()); diff != "" {
+		t.Errorf("relabelFamilies() difference (-got +want):\n%s", diff)
+	}
+}
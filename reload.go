@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// diffLines compares two texts split into lines. The result contains the
+// lines only in a prefixed with "-" and the lines only in b prefixed with "+",
+// ordered along their longest common subsequence.
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var result []string
+
+	for i, j := 0, 0; i < len(a) || j < len(b); {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			result = append(result, "-"+a[i])
+			i++
+
+		default:
+			result = append(result, "+"+b[j])
+			j++
+		}
+	}
+
+	return result
+}
+
+// diffJobs describes the differences between two sets of jobs, one entry per
+// added, removed or changed job.
+func diffJobs(oldJobs, newJobs []*mergeJob) []string {
+	var result []string
+
+	oldByName := map[string]*mergeJob{}
+
+	for _, j := range oldJobs {
+		oldByName[j.name] = j
+	}
+
+	newByName := map[string]*mergeJob{}
+
+	for _, j := range newJobs {
+		newByName[j.name] = j
+	}
+
+	for _, j := range oldJobs {
+		if newByName[j.name] == nil {
+			result = append(result, fmt.Sprintf("Removed job %q", j.name))
+		}
+	}
+
+	for _, j := range newJobs {
+		prev := oldByName[j.name]
+
+		if prev == nil {
+			result = append(result, fmt.Sprintf("Added job %q", j.name))
+			continue
+		}
+
+		if changes := diffLines(splitLines(prev.config), splitLines(j.config)); len(changes) > 0 {
+			result = append(result, fmt.Sprintf("Changed job %q:\n%s", j.name, strings.Join(changes, "\n")))
+		}
+	}
+
+	return result
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// reloadJobs loads the jobs again and logs the changes. The current jobs are
+// kept if loading fails, in which case the returned boolean is false.
+func reloadJobs(current []*mergeJob, load func() ([]*mergeJob, error)) ([]*mergeJob, bool) {
+	jobs, err := load()
+	if err != nil {
+		log.Printf("Reloading configuration failed, keeping previous configuration: %v", err)
+		return current, false
+	}
+
+	changes := diffJobs(current, jobs)
+
+	if len(changes) == 0 {
+		log.Print("Reloaded configuration without changes")
+	}
+
+	for _, i := range changes {
+		log.Printf("Reloaded configuration: %s", i)
+	}
+
+	return jobs, true
+}
+
+// runWithReload invokes fn with the given jobs until it returns. Whenever a
+// value is received from the reload channel the jobs are loaded again and fn
+// is restarted with them. If fn fails with reloaded jobs, e.g. because a
+// watched directory doesn't exist, it is restarted with the previous jobs. fn
+// must return when its context is cancelled.
+func runWithReload(ctx context.Context, reload <-chan os.Signal, jobs []*mergeJob, load func() ([]*mergeJob, error), fn func(context.Context, []*mergeJob) error) error {
+	// Jobs which ran successfully before the last reload
+	var previous []*mergeJob
+
+	for {
+		runCtx, cancel := context.WithCancel(ctx)
+		done := make(chan error, 1)
+
+		go func(jobs []*mergeJob) {
+			done <- fn(runCtx, jobs)
+		}(jobs)
+
+	WAIT:
+		for {
+			select {
+			case err := <-done:
+				cancel()
+
+				if err == nil || previous == nil || ctx.Err() != nil {
+					return err
+				}
+
+				log.Printf("Running reloaded configuration failed, restoring previous configuration: %v", err)
+
+				jobs, previous = previous, nil
+
+				break WAIT
+
+			case <-reload:
+				reloaded, ok := reloadJobs(jobs, load)
+				if !ok {
+					continue
+				}
+
+				cancel()
+
+				if err := <-done; err != nil {
+					return err
+				}
+
+				jobs, previous = reloaded, jobs
+
+				break WAIT
+			}
+		}
+	}
+}
+
+// swappableHandler forwards requests to a handler which can be replaced while
+// serving.
+type swappableHandler struct {
+	handler atomic.Pointer[http.Handler]
+}
+
+var _ http.Handler = (*swappableHandler)(nil)
+
+func newSwappableHandler(h http.Handler) *swappableHandler {
+	s := &swappableHandler{}
+	s.set(h)
+
+	return s
+}
+
+func (s *swappableHandler) set(h http.Handler) {
+	s.handler.Store(&h)
+}
+
+func (s *swappableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	(*s.handler.Load()).ServeHTTP(w, r)
+}
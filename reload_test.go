@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestDiffLines(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		a    []string
+		b    []string
+		want []string
+	}{
+		{name: "empty"},
+		{
+			name: "same",
+			a:    []string{"a", "b"},
+			b:    []string{"a", "b"},
+		},
+		{
+			name: "added",
+			b:    []string{"a"},
+			want: []string{"+a"},
+		},
+		{
+			name: "removed",
+			a:    []string{"a", "b", "c"},
+			b:    []string{"a", "c"},
+			want: []string{"-b"},
+		},
+		{
+			name: "changed",
+			a:    []string{"name: x", "timeout: 0s", "lock: false"},
+			b:    []string{"name: x", "timeout: 5s", "lock: false", "validate: true"},
+			want: []string{"-timeout: 0s", "+timeout: 5s", "+validate: true"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := diffLines(tc.a, tc.b)
+
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("diffLines() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiffJobs(t *testing.T) {
+	parse := func(config string) []*mergeJob {
+		t.Helper()
+
+		jobs, err := parseConfig(strings.NewReader(config), jobDefaults{})
+		if err != nil {
+			t.Fatalf("parseConfig() failed: %v", err)
+		}
+
+		return jobs
+	}
+
+	oldJobs := parse(`
+jobs:
+  - name: kept
+    inputs: [{files: [a.prom]}]
+  - name: changed
+    inputs: [{files: [a.prom]}]
+  - name: removed
+    inputs: [{files: [a.prom]}]
+`)
+
+	newJobs := parse(`
+jobs:
+  - name: changed
+    inputs: [{files: [b.prom]}]
+  - name: kept
+    inputs: [{files: [a.prom]}]
+  - name: added
+    inputs: [{files: [a.prom]}]
+`)
+
+	want := []string{
+		`Removed job "removed"`,
+		"Changed job \"changed\":\n-        - a.prom\n+        - b.prom",
+		`Added job "added"`,
+	}
+
+	if diff := cmp.Diff(diffJobs(oldJobs, newJobs), want); diff != "" {
+		t.Errorf("diffJobs() difference (-got +want):\n%s", diff)
+	}
+
+	if got := diffJobs(oldJobs, oldJobs); len(got) != 0 {
+		t.Errorf("diffJobs() reported changes for identical jobs: %q", got)
+	}
+}
+
+func TestRunWithReload(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	reload := make(chan os.Signal)
+	started := make(chan string)
+	done := make(chan error, 1)
+
+	var loadErr error
+
+	load := func() ([]*mergeJob, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+
+		return []*mergeJob{{name: "reloaded"}}, nil
+	}
+
+	go func() {
+		done <- runWithReload(ctx, reload, []*mergeJob{{name: "initial"}}, load, func(ctx context.Context, jobs []*mergeJob) error {
+			started <- jobs[0].name
+			<-ctx.Done()
+			return nil
+		})
+	}()
+
+	waitForStart := func(want string) {
+		t.Helper()
+
+		select {
+		case got := <-started:
+			if got != want {
+				t.Errorf("Started with job %q, want %q", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timeout while waiting for start")
+		}
+	}
+
+	waitForStart("initial")
+
+	reload <- syscall.SIGHUP
+
+	waitForStart("reloaded")
+
+	// Failing to load keeps the current jobs running
+	loadErr = errors.New("test")
+	reload <- syscall.SIGHUP
+
+	select {
+	case name := <-started:
+		t.Errorf("Unexpected restart with job %q", name)
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("runWithReload() failed: %v", err)
+	}
+}
+
+func TestRunWithReloadRestorePrevious(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	reload := make(chan os.Signal)
+	started := make(chan string)
+	done := make(chan error, 1)
+
+	load := func() ([]*mergeJob, error) {
+		return []*mergeJob{{name: "broken"}}, nil
+	}
+
+	go func() {
+		done <- runWithReload(ctx, reload, []*mergeJob{{name: "initial"}}, load, func(ctx context.Context, jobs []*mergeJob) error {
+			started <- jobs[0].name
+
+			if jobs[0].name == "broken" {
+				// E.g. a watched directory doesn't exist
+				return errors.New("test")
+			}
+
+			<-ctx.Done()
+			return nil
+		})
+	}()
+
+	for _, want := range []string{"initial", "broken", "initial"} {
+		if want == "broken" {
+			reload <- syscall.SIGHUP
+		}
+
+		select {
+		case got := <-started:
+			if got != want {
+				t.Errorf("Started with job %q, want %q", got, want)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timeout while waiting for start")
+		}
+	}
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("runWithReload() failed: %v", err)
+	}
+}
+
+func TestSwappableHandler(t *testing.T) {
+	handler := newSwappableHandler(http.NotFoundHandler())
+
+	for _, want := range []int{http.StatusNotFound, http.StatusTeapot} {
+		rec := httptest.NewRecorder()
+
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if got := rec.Result().StatusCode; got != want {
+			t.Errorf("Status code %d, want %d", got, want)
+		}
+
+		handler.set(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusTeapot)
+		}))
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// sample is a single value of a metric family as it would appear in the text
+// format, e.g. an individual histogram bucket.
+type sample struct {
+	// Name and sorted labels, e.g. `http_requests_total{code="200"}`.
+	series string
+	value  float64
+}
+
+// formatSeries formats a metric name and labels. Labels are sorted by name.
+func formatSeries(name string, labels []*dto.LabelPair, extra ...*dto.LabelPair) string {
+	all := make([]*dto.LabelPair, 0, len(labels)+len(extra))
+	all = append(all, labels...)
+	all = append(all, extra...)
+
+	if len(all) == 0 {
+		return name
+	}
+
+	sort.SliceStable(all, func(a, b int) bool {
+		return all[a].GetName() < all[b].GetName()
+	})
+
+	var sb strings.Builder
+
+	sb.WriteString(name)
+	sb.WriteByte('{')
+
+	for idx, i := range all {
+		if idx > 0 {
+			sb.WriteByte(',')
+		}
+
+		sb.WriteString(i.GetName())
+		sb.WriteByte('=')
+		sb.WriteString(strconv.Quote(i.GetValue()))
+	}
+
+	sb.WriteByte('}')
+
+	return sb.String()
+}
+
+// formatValue formats a sample value like the text format.
+func formatValue(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, +1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// familyTypeName returns the type of a metric family as written in the text
+// format.
+func familyTypeName(mf *dto.MetricFamily) string {
+	return strings.ToLower(mf.GetType().String())
+}
+
+func newLabelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+// histogramCount returns the sample count of a histogram. Non-integer counts
+// are stored separately.
+func histogramCount(h *dto.Histogram) float64 {
+	if h.SampleCountFloat != nil {
+		return h.GetSampleCountFloat()
+	}
+
+	return float64(h.GetSampleCount())
+}
+
+// bucketCount returns the cumulative count of a histogram bucket.
+func bucketCount(b *dto.Bucket) float64 {
+	if b.CumulativeCountFloat != nil {
+		return b.GetCumulativeCountFloat()
+	}
+
+	return float64(b.GetCumulativeCount())
+}
+
+// familySamples flattens a metric family into individual samples. Summaries
+// and histograms produce one sample per quantile or bucket in addition to
+// their sum and count.
+func familySamples(mf *dto.MetricFamily) []sample {
+	var result []sample
+
+	name := mf.GetName()
+
+	for _, m := range mf.GetMetric() {
+		labels := m.GetLabel()
+
+		add := func(suffix string, value float64, extra ...*dto.LabelPair) {
+			result = append(result, sample{
+				series: formatSeries(name+suffix, labels, extra...),
+				value:  value,
+			})
+		}
+
+		switch {
+		case m.Counter != nil:
+			add("", m.GetCounter().GetValue())
+
+		case m.Gauge != nil:
+			add("", m.GetGauge().GetValue())
+
+		case m.Summary != nil:
+			s := m.GetSummary()
+
+			for _, q := range s.GetQuantile() {
+				add("", q.GetValue(), newLabelPair(model.QuantileLabel, formatValue(q.GetQuantile())))
+			}
+
+			add("_sum", s.GetSampleSum())
+			add("_count", float64(s.GetSampleCount()))
+
+		case m.Histogram != nil:
+			h := m.GetHistogram()
+
+			buckets := h.GetBucket()
+
+			for _, b := range buckets {
+				add("_bucket", bucketCount(b), newLabelPair(model.BucketLabel, formatValue(b.GetUpperBound())))
+			}
+
+			// The text format always includes the +Inf bucket
+			if len(buckets) == 0 || !math.IsInf(buckets[len(buckets)-1].GetUpperBound(), +1) {
+				add("_bucket", histogramCount(h), newLabelPair(model.BucketLabel, "+Inf"))
+			}
+
+			add("_sum", h.GetSampleSum())
+			add("_count", histogramCount(h))
+
+		default:
+			add("", m.GetUntyped().GetValue())
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// mustParseInput parses the given text format content.
+func mustParseInput(t *testing.T, name, content string) parsedInput {
+	t.Helper()
+
+	p, err := readMetricFamilies(context.Background(), newReaderInputWrapper(newFakeReaderWithName(name, content)), nil)
+	if err != nil {
+		t.Fatalf("readMetricFamilies() failed: %v", err)
+	}
+
+	return p
+}
+
+func TestFamilySamples(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    []sample
+	}{
+		{
+			name:    "counter",
+			content: "# TYPE test counter\ntest{z=\"1\",a=\"x\\\"y\"} 10\ntest 20\n",
+			want: []sample{
+				{series: `test{a="x\"y",z="1"}`, value: 10},
+				{series: `test`, value: 20},
+			},
+		},
+		{
+			name:    "untyped",
+			content: "test -1.5\n",
+			want: []sample{
+				{series: `test`, value: -1.5},
+			},
+		},
+		{
+			name:    "summary",
+			content: "# TYPE test summary\ntest{quantile=\"0.5\"} 4\ntest_sum 10\ntest_count 3\n",
+			want: []sample{
+				{series: `test{quantile="0.5"}`, value: 4},
+				{series: `test_sum`, value: 10},
+				{series: `test_count`, value: 3},
+			},
+		},
+		{
+			name:    "histogram",
+			content: "# TYPE test histogram\ntest_bucket{le=\"1\"} 1\ntest_bucket{le=\"+Inf\"} 2\ntest_sum 3\ntest_count 2\n",
+			want: []sample{
+				{series: `test_bucket{le="1"}`, value: 1},
+				{series: `test_bucket{le="+Inf"}`, value: 2},
+				{series: `test_sum`, value: 3},
+				{series: `test_count`, value: 2},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			p := mustParseInput(t, "input", tc.content)
+
+			got := familySamples(p.families["test"])
+
+			if diff := cmp.Diff(got, tc.want, cmp.AllowUnexported(sample{})); diff != "" {
+				t.Errorf("familySamples() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const selfMetricsPrefix = "textformat_merge_"
+
+// mergeStats describes a single merge. Generated families are not included.
+type mergeStats struct {
+	duration      time.Duration
+	inputs        int
+	skippedInputs int
+	bytesRead     int64
+	families      int
+	series        int
+
+	// Number of times differing non-empty help strings were resolved.
+	helpConflicts int
+
+	// Number of series dropped due to limits.
+	truncatedSeries int
+}
+
+// selfMetricFamilies generates families describing a merge.
+func selfMetricFamilies(stats mergeStats) map[string]*dto.MetricFamily {
+	result := map[string]*dto.MetricFamily{}
+
+	for _, i := range []struct {
+		name  string
+		help  string
+		value float64
+	}{
+		{"duration_seconds", "Time spent reading and merging inputs.", stats.duration.Seconds()},
+		{"inputs", "Number of inputs merged.", float64(stats.inputs)},
+		{"inputs_skipped", "Number of inputs skipped due to errors.", float64(stats.skippedInputs)},
+		{"read_bytes", "Number of bytes read from merged inputs.", float64(stats.bytesRead)},
+		{"families", "Number of metric families, excluding generated families.", float64(stats.families)},
+		{"series", "Number of series, excluding generated series.", float64(stats.series)},
+		{"help_conflicts", "Number of times differing help strings were resolved.", float64(stats.helpConflicts)},
+		{"series_truncated", "Number of series dropped for exceeding limits.", float64(stats.truncatedSeries)},
+	} {
+		mf := newGaugeFamily(selfMetricsPrefix+i.name, i.help)
+		mf.Metric = []*dto.Metric{
+			{Gauge: &dto.Gauge{Value: proto.Float64(i.value)}},
+		}
+
+		result[mf.GetName()] = mf
+	}
+
+	return result
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+)
+
+// mergeHandler reads and merges all inputs on every request.
+type mergeHandler struct {
+	// Returns the inputs to read. Invoked for every request so that
+	// directories are enumerated again.
+	inputs func() ([]inputWrapper, error)
+
+	opts       mergeOptions
+	showInputs bool
+
+	// Maximum duration of merging for a single request, zero disables the
+	// timeout.
+	timeout time.Duration
+}
+
+var _ http.Handler = (*mergeHandler)(nil)
+
+func (h *mergeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	inputs, err := h.inputs()
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+
+	merged, err := readAndMerge(ctx, inputs, h.opts)
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	defer merged.close()
+
+	for _, err := range merged.failures {
+		log.Printf("Skipped input: %v", err)
+	}
+
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+
+	// Buffer the output to report errors with an appropriate status code.
+	var buf bytes.Buffer
+
+	if format.FormatType() == expfmt.TypeTextPlain {
+		err = merged.write(&buf, h.showInputs)
+	} else {
+		err = merged.encode(&buf, format)
+	}
+
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", string(format))
+	w.Write(buf.Bytes())
+}
+
+// serve answers HTTP requests on the given address until the context is
+// cancelled.
+func serve(ctx context.Context, address, metricsPath string, handler http.Handler) error {
+	mux := http.NewServeMux()
+	mux.Handle(metricsPath, handler)
+
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Serving merged metrics at http://%s%s", listener.Addr(), metricsPath)
+
+	errCh := make(chan error, 1)
+
+	go func() {
+		errCh <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMergeHandler(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	for name, content := range map[string]string{
+		"a.prom": "# TYPE requests_total counter\nrequests_total{kind=\"a\"} 10\n",
+		"b.prom": "# TYPE requests_total counter\nrequests_total{kind=\"b\"} 20\n",
+	} {
+		if err := os.WriteFile(filepath.Join(tmpdir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	handler := &mergeHandler{
+		inputs: func() ([]inputWrapper, error) {
+			return inputWrappersFromDirs([]string{tmpdir}, "*.prom", inputOptions{})
+		},
+	}
+
+	for _, tc := range []struct {
+		name            string
+		accept          string
+		wantContentType string
+		want            string
+	}{
+		{
+			name:            "text",
+			wantContentType: "text/plain; version=0.0.4; charset=utf-8; escaping=underscores",
+			want: `# TYPE requests_total counter
+requests_total{kind="a"} 10
+requests_total{kind="b"} 20
+`,
+		},
+		{
+			name:            "openmetrics",
+			accept:          "application/openmetrics-text; version=1.0.0",
+			wantContentType: "application/openmetrics-text; version=1.0.0; charset=utf-8; escaping=underscores",
+			want: `# TYPE requests counter
+requests_total{kind="a"} 10.0
+requests_total{kind="b"} 20.0
+# EOF
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			resp := rec.Result()
+
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Status code %d, want %d: %s", resp.StatusCode, http.StatusOK, body)
+			}
+
+			if diff := cmp.Diff(resp.Header.Get("Content-Type"), tc.wantContentType); diff != "" {
+				t.Errorf("Content type difference (-got +want):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(string(body), tc.want); diff != "" {
+				t.Errorf("Body difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMergeHandlerError(t *testing.T) {
+	handler := &mergeHandler{
+		inputs: func() ([]inputWrapper, error) {
+			return []inputWrapper{
+				newReaderInputWrapper(newFakeReaderWithName("bad.prom", "x y\n")),
+			}, nil
+		},
+	}
+
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Result().StatusCode; got != http.StatusInternalServerError {
+		t.Errorf("Status code %d, want %d", got, http.StatusInternalServerError)
+	}
+
+	if got := rec.Body.String(); !strings.HasPrefix(got, "bad.prom:1:3: ") {
+		t.Errorf("Body %q doesn't contain parse error", got)
+	}
+}
+
+func TestMergeHandlerTimeout(t *testing.T) {
+	// Nothing is ever written to the pipe
+	pr, pw := io.Pipe()
+	t.Cleanup(func() { pw.Close() })
+
+	handler := &mergeHandler{
+		inputs: func() ([]inputWrapper, error) {
+			return []inputWrapper{newReaderInputWrapper(pr)}, nil
+		},
+		timeout: 100 * time.Millisecond,
+	}
+
+	rec := httptest.NewRecorder()
+
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	if got := rec.Result().StatusCode; got != http.StatusInternalServerError {
+		t.Errorf("Status code %d, want %d", got, http.StatusInternalServerError)
+	}
+
+	if got := rec.Body.String(); !strings.Contains(got, context.DeadlineExceeded.Error()) {
+		t.Errorf("Body %q doesn't report deadline", got)
+	}
+}
+
+func TestJobsHandler(t *testing.T) {
+	tmpdir := t.TempDir()
+	path := filepath.Join(tmpdir, "a.prom")
+
+	if err := os.WriteFile(path, []byte("# TYPE up gauge\nup 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := newJobsHandler("/metrics", []*mergeJob{
+		{
+			name:    "node",
+			sources: []inputSource{{files: []string{path}}},
+		},
+	})
+
+	for _, tc := range []struct {
+		path       string
+		wantStatus int
+		want       string
+	}{
+		{path: "/metrics/node", wantStatus: http.StatusOK, want: "# TYPE up gauge\nup 1\n"},
+		{path: "/metrics/other", wantStatus: http.StatusNotFound},
+		{path: "/metrics/", wantStatus: http.StatusNotFound},
+		{path: "/metrics/node/x", wantStatus: http.StatusNotFound},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if got := rec.Result().StatusCode; got != tc.wantStatus {
+				t.Errorf("Status code %d, want %d", got, tc.wantStatus)
+			}
+
+			if tc.want != "" {
+				if diff := cmp.Diff(rec.Body.String(), tc.want); diff != "" {
+					t.Errorf("Body difference (-got +want):\n%s", diff)
+				}
+			}
+		})
+	}
+}
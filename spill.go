@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/encoding/protodelim"
+)
+
+// spillStore keeps metrics in temporary files to reduce memory usage. Each file
+// contains families sorted by name in the length-delimited protocol buffer
+// format.
+type spillStore struct {
+	// Directory for temporary files, empty for the default.
+	dir   string
+	files []string
+}
+
+// write stores the metrics of the given families, which must be sorted by
+// name, in a new file. Families without metrics are skipped.
+func (s *spillStore) write(families []*dto.MetricFamily) (err error) {
+	fh, err := os.CreateTemp(s.dir, "textformat-merge-*.spill")
+	if err != nil {
+		return err
+	}
+
+	s.files = append(s.files, fh.Name())
+
+	defer func() {
+		if closeErr := fh.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	bw := bufio.NewWriter(fh)
+
+	for _, mf := range families {
+		if len(mf.GetMetric()) == 0 {
+			continue
+		}
+
+		if _, err := protodelim.MarshalTo(bw, mf); err != nil {
+			return fmt.Errorf("%s: %w", fh.Name(), err)
+		}
+	}
+
+	return bw.Flush()
+}
+
+// open returns a reader for each file in the order they were written.
+func (s *spillStore) open() ([]*spillReader, error) {
+	var result []*spillReader
+
+	for _, path := range s.files {
+		fh, err := os.Open(path)
+		if err != nil {
+			closeSpillReaders(result)
+			return nil, err
+		}
+
+		result = append(result, &spillReader{
+			fh: fh,
+			r:  bufio.NewReader(fh),
+		})
+	}
+
+	return result, nil
+}
+
+// remove deletes all files.
+func (s *spillStore) remove() error {
+	var errs []error
+
+	for _, path := range s.files {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+
+	s.files = nil
+
+	return errors.Join(errs...)
+}
+
+type spillReader struct {
+	fh   *os.File
+	r    *bufio.Reader
+	next *dto.MetricFamily
+	eof  bool
+}
+
+// take returns the metrics of the named family if it's in the file. Families
+// must be requested in sorted order; families sorting before the requested
+// name are skipped.
+func (r *spillReader) take(name string) ([]*dto.Metric, error) {
+	for !r.eof && (r.next == nil || r.next.GetName() < name) {
+		mf := &dto.MetricFamily{}
+
+		opts := protodelim.UnmarshalOptions{
+			MaxSize: -1,
+		}
+
+		if err := opts.UnmarshalFrom(r.r, mf); err == io.EOF {
+			r.eof = true
+			r.next = nil
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", r.fh.Name(), err)
+		} else {
+			r.next = mf
+		}
+	}
+
+	if r.next == nil || r.next.GetName() != name {
+		return nil, nil
+	}
+
+	metrics := r.next.Metric
+	r.next = nil
+
+	return metrics, nil
+}
+
+func closeSpillReaders(readers []*spillReader) {
+	for _, r := range readers {
+		r.fh.Close()
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestReadAndMergeSpill(t *testing.T) {
+	inputs := func() []inputWrapper {
+		return []inputWrapper{
+			newReaderInputWrapper(newFakeReaderWithName("a.txt",
+				"# HELP up Up.\n# TYPE up gauge\nup{job=\"a\"} 1\n# TYPE load gauge\nload 1\n")),
+			newReaderInputWrapper(newFakeReaderWithName("b.txt",
+				"# TYPE up gauge\nup{job=\"b\"} 0\n# TYPE other counter\nother 7\n")),
+			newReaderInputWrapper(newFakeReaderWithName("c.txt",
+				"# HELP up Targets up.\n# TYPE up gauge\nup{job=\"c\"} 1\n# TYPE load gauge\nload 2\n")),
+			newReaderInputWrapper(newFakeReaderWithName("d.txt",
+				"# TYPE other gauge\nother 1\n")),
+		}
+	}
+
+	want, err := readAndMerge(context.Background(), inputs(), mergeOptions{onConflict: conflictPolicySkipInput})
+	if err != nil {
+		t.Fatalf("readAndMerge() failed: %v", err)
+	}
+
+	var wantText strings.Builder
+
+	if err := want.write(&wantText, false); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	spillDir := t.TempDir()
+
+	got, err := readAndMerge(context.Background(), inputs(), mergeOptions{
+		onConflict:     conflictPolicySkipInput,
+		spillThreshold: 1,
+		spillDir:       spillDir,
+	})
+	if err != nil {
+		t.Fatalf("readAndMerge() failed: %v", err)
+	}
+
+	if got.spill == nil || len(got.spill.files) != 3 {
+		t.Errorf("Expected three spill files, got %+v", got.spill)
+	}
+
+	if len(got.failures) != 1 {
+		t.Errorf("readAndMerge() reported failures %q, want 1", got.failures)
+	}
+
+	var gotText strings.Builder
+
+	if err := got.write(&gotText, false); err != nil {
+		t.Fatalf("write() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(gotText.String(), wantText.String()); diff != "" {
+		t.Errorf("Output difference (-got +want):\n%s", diff)
+	}
+
+	if err := got.close(); err != nil {
+		t.Errorf("close() failed: %v", err)
+	}
+
+	if entries, err := os.ReadDir(spillDir); err != nil {
+		t.Error(err)
+	} else if len(entries) != 0 {
+		t.Errorf("Temporary files not removed: %v", entries)
+	}
+}
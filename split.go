@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// splitKeyFunc returns the name of the group a metric belongs to. An empty
+// name drops the metric.
+type splitKeyFunc func(mf *dto.MetricFamily, m *dto.Metric) string
+
+// splitFamilies partitions the metrics of all families into groups. The order
+// of families and metrics is preserved within each group.
+func splitFamilies(families []*dto.MetricFamily, keyFn splitKeyFunc) map[string][]*dto.MetricFamily {
+	result := map[string][]*dto.MetricFamily{}
+
+	for _, mf := range families {
+		partial := map[string]*dto.MetricFamily{}
+		var keys []string
+
+		for _, m := range mf.GetMetric() {
+			key := keyFn(mf, m)
+			if key == "" {
+				continue
+			}
+
+			dst := partial[key]
+			if dst == nil {
+				dst = &dto.MetricFamily{
+					Name: mf.Name,
+					Help: mf.Help,
+					Type: mf.Type,
+					Unit: mf.Unit,
+				}
+				partial[key] = dst
+				keys = append(keys, key)
+			}
+
+			dst.Metric = append(dst.Metric, m)
+		}
+
+		for _, key := range keys {
+			result[key] = append(result[key], partial[key])
+		}
+	}
+
+	return result
+}
+
+var unsafeFileNameCharRe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// sanitizeFileName replaces characters which are unsafe in file names.
+func sanitizeFileName(name string) string {
+	name = unsafeFileNameCharRe.ReplaceAllString(name, "_")
+
+	if strings.Trim(name, ".") == "" {
+		name = strings.Repeat("_", len(name))
+	}
+
+	return name
+}
+
+type splitGroup struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// splitGroupsFlag collects "name=regexp" arguments.
+type splitGroupsFlag []splitGroup
+
+var _ flag.Value = (*splitGroupsFlag)(nil)
+
+func (f *splitGroupsFlag) String() string {
+	var parts []string
+
+	for _, i := range *f {
+		parts = append(parts, i.name+"="+i.re.String())
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func (f *splitGroupsFlag) Set(value string) error {
+	name, expr, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("%q is not of the form name=regexp", value)
+	}
+
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return err
+	}
+
+	*f = append(*f, splitGroup{name: name, re: re})
+
+	return nil
+}
+
+type splitCommand struct {
+	outputDir     string
+	by            string
+	label         string
+	groups        splitGroupsFlag
+	fallback      string
+	suffix        string
+	onlyIfChanged bool
+}
+
+var _ subcommand = (*splitCommand)(nil)
+
+func (c *splitCommand) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.outputDir, "output-dir", "", "Directory to write files to (required)")
+	fs.StringVar(&c.by, "by", "family", `Criteria for splitting: "family", "label" (see --label) or "group" (see --group)`)
+	fs.StringVar(&c.label, "label", "", "Name of label whose values determine the file names")
+	fs.Var(&c.groups, "group", "Group families with names fully matching a regular expression (\"name=regexp\"; can be repeated)")
+	fs.StringVar(&c.fallback, "fallback", "other", "File name for series without the label or families without a matching group (empty to drop them)")
+	fs.StringVar(&c.suffix, "suffix", ".prom", "Suffix for file names")
+	fs.BoolVar(&c.onlyIfChanged, "only-if-changed", false, "Keep output files, including their modification time, if their content would not change")
+}
+
+func (c *splitCommand) keyFunc() (splitKeyFunc, error) {
+	switch c.by {
+	case "family":
+		return func(mf *dto.MetricFamily, _ *dto.Metric) string {
+			return mf.GetName()
+		}, nil
+
+	case "label":
+		if c.label == "" {
+			return nil, errors.New("--label is required when splitting by label")
+		}
+
+		return func(_ *dto.MetricFamily, m *dto.Metric) string {
+			for _, lp := range m.GetLabel() {
+				if lp.GetName() == c.label && lp.GetValue() != "" {
+					return lp.GetValue()
+				}
+			}
+
+			return c.fallback
+		}, nil
+
+	case "group":
+		if len(c.groups) == 0 {
+			return nil, errors.New("at least one --group is required when splitting by group")
+		}
+
+		return func(mf *dto.MetricFamily, _ *dto.Metric) string {
+			for _, g := range c.groups {
+				if g.re.MatchString(mf.GetName()) {
+					return g.name
+				}
+			}
+
+			return c.fallback
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown split criteria %q", c.by)
+}
+
+func (c *splitCommand) run(ctx context.Context, fs *flag.FlagSet) error {
+	if c.outputDir == "" {
+		return &usageError{errors.New("--output-dir is required")}
+	}
+
+	keyFn, err := c.keyFunc()
+	if err != nil {
+		return &usageError{err}
+	}
+
+	inputPaths := fs.Args()
+
+	if len(inputPaths) == 0 {
+		inputPaths = []string{stdinPlaceholder}
+	}
+
+	merged, err := readAndMerge(ctx, inputWrappersFromArgs(inputPaths, inputOptions{}), mergeOptions{})
+	if err != nil {
+		return err
+	}
+
+	groups := splitFamilies(merged.families, keyFn)
+
+	keys := make([]string, 0, len(groups))
+
+	for key := range groups {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	// Multiple groups may map to the same file name after sanitizing
+	var paths []string
+
+	byPath := map[string]*metricsMerger{}
+
+	for _, key := range keys {
+		path := filepath.Join(c.outputDir, sanitizeFileName(key)+c.suffix)
+
+		merger := byPath[path]
+		if merger == nil {
+			merger = newMetricsMerger(mergeOptions{})
+			byPath[path] = merger
+			paths = append(paths, path)
+		}
+
+		families := map[string]*dto.MetricFamily{}
+
+		for _, mf := range groups[key] {
+			families[mf.GetName()] = mf
+		}
+
+		if err := merger.mergeFamilies(key, families); err != nil {
+			return err
+		}
+	}
+
+	opts := outputOptions{
+		onlyIfChanged: c.onlyIfChanged,
+	}
+
+	for _, path := range paths {
+		content := byPath[path].finalize()
+
+		if err := withFileOutput(path, opts, func(w io.Writer) error {
+			return content.write(w, false)
+		}); err != nil {
+			return &outputError{err}
+		}
+	}
+
+	return nil
+}
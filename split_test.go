@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{name: "", want: ""},
+		{name: "node", want: "node"},
+		{name: "a/b c", want: "a_b_c"},
+		{name: "..", want: "__"},
+		{name: ".hidden", want: ".hidden"},
+		{name: "größe", want: "gr__e"},
+	} {
+		if got := sanitizeFileName(tc.name); got != tc.want {
+			t.Errorf("sanitizeFileName(%q) returned %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSplitCommand(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input.prom")
+
+	if err := os.WriteFile(input, []byte(`# HELP up Target is up.
+# TYPE up gauge
+up{job="node"} 1
+up{job="mysql"} 0
+up{job="web/frontend"} 1
+up 1
+# TYPE mysql_connections gauge
+mysql_connections{job="mysql"} 17
+# TYPE node_load1 gauge
+node_load1{job="node"} 0.5
+`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name string
+		args []string
+		want map[string]string
+	}{
+		{
+			name: "family",
+			want: map[string]string{
+				"mysql_connections.prom": "# TYPE mysql_connections gauge\nmysql_connections{job=\"mysql\"} 17\n",
+				"node_load1.prom":        "# TYPE node_load1 gauge\nnode_load1{job=\"node\"} 0.5\n",
+				"up.prom": `# HELP up Target is up.
+# TYPE up gauge
+up{job="node"} 1
+up{job="mysql"} 0
+up{job="web/frontend"} 1
+up 1
+`,
+			},
+		},
+		{
+			name: "label",
+			args: []string{"--by", "label", "--label", "job", "--fallback", ""},
+			want: map[string]string{
+				"mysql.prom": `# TYPE mysql_connections gauge
+mysql_connections{job="mysql"} 17
+# HELP up Target is up.
+# TYPE up gauge
+up{job="mysql"} 0
+`,
+				"node.prom": `# TYPE node_load1 gauge
+node_load1{job="node"} 0.5
+# HELP up Target is up.
+# TYPE up gauge
+up{job="node"} 1
+`,
+				"web_frontend.prom": "# HELP up Target is up.\n# TYPE up gauge\nup{job=\"web/frontend\"} 1\n",
+			},
+		},
+		{
+			name: "group",
+			args: []string{"--by", "group", "--group", "db=mysql_.*", "--group", "host=node_.*", "--suffix", ".txt"},
+			want: map[string]string{
+				"db.txt":   "# TYPE mysql_connections gauge\nmysql_connections{job=\"mysql\"} 17\n",
+				"host.txt": "# TYPE node_load1 gauge\nnode_load1{job=\"node\"} 0.5\n",
+				"other.txt": `# HELP up Target is up.
+# TYPE up gauge
+up{job="node"} 1
+up{job="mysql"} 0
+up{job="web/frontend"} 1
+up 1
+`,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			outputDir := t.TempDir()
+
+			var cmd splitCommand
+
+			fs := flag.NewFlagSet("", flag.ContinueOnError)
+
+			cmd.register(fs)
+
+			if err := fs.Parse(append(append([]string{"--output-dir", outputDir}, tc.args...), input)); err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+
+			if err := cmd.run(context.Background(), fs); err != nil {
+				t.Errorf("run() failed: %v", err)
+			}
+
+			entries, err := os.ReadDir(outputDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := map[string]string{}
+
+			for _, i := range entries {
+				content, err := os.ReadFile(filepath.Join(outputDir, i.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				got[i.Name()] = string(content)
+			}
+
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("Files difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+type inputStats struct {
+	Name     string `json:"name"`
+	Families int    `json:"families"`
+	Series   int    `json:"series"`
+	Samples  int    `json:"samples"`
+}
+
+type familyStats struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Series  int    `json:"series"`
+	Samples int    `json:"samples"`
+}
+
+// labelStats describes how a label name is used across all families.
+type labelStats struct {
+	Name     string `json:"name"`
+	Values   int    `json:"values"`
+	Series   int    `json:"series"`
+	Families int    `json:"families"`
+}
+
+// cardinalityStats summarizes the inputs. Series are the metrics of
+// a family while samples are the lines in the text format, e.g. each bucket
+// of a histogram.
+type cardinalityStats struct {
+	Series        int           `json:"series"`
+	Samples       int           `json:"samples"`
+	TotalFamilies int           `json:"total_families"`
+	Inputs        []inputStats  `json:"inputs"`
+	Families      []familyStats `json:"families"`
+	Labels        []labelStats  `json:"labels"`
+}
+
+// computeStats collects statistics over all inputs. Families and labels are
+// sorted by decreasing cardinality and limited to the given number of entries
+// (zero for all).
+func computeStats(inputs []parsedInput, top int) cardinalityStats {
+	result := cardinalityStats{
+		Inputs:   []inputStats{},
+		Families: []familyStats{},
+		Labels:   []labelStats{},
+	}
+
+	families := map[string]*familyStats{}
+	labels := map[string]*labelStats{}
+	labelValues := map[string]map[string]struct{}{}
+	labelFamilies := map[string]map[string]struct{}{}
+
+	for _, p := range inputs {
+		is := inputStats{
+			Name:     p.name,
+			Families: len(p.families),
+		}
+
+		for _, mf := range sortedFamilies(p.families) {
+			fs := families[mf.GetName()]
+			if fs == nil {
+				fs = &familyStats{
+					Name: mf.GetName(),
+					Type: familyTypeName(mf),
+				}
+				families[mf.GetName()] = fs
+			}
+
+			samples := len(familySamples(mf))
+
+			fs.Series += len(mf.GetMetric())
+			fs.Samples += samples
+			is.Series += len(mf.GetMetric())
+			is.Samples += samples
+
+			for _, m := range mf.GetMetric() {
+				for _, lp := range m.GetLabel() {
+					name := lp.GetName()
+
+					ls := labels[name]
+					if ls == nil {
+						ls = &labelStats{Name: name}
+						labels[name] = ls
+						labelValues[name] = map[string]struct{}{}
+						labelFamilies[name] = map[string]struct{}{}
+					}
+
+					ls.Series++
+					labelValues[name][lp.GetValue()] = struct{}{}
+					labelFamilies[name][mf.GetName()] = struct{}{}
+				}
+			}
+		}
+
+		result.Series += is.Series
+		result.Samples += is.Samples
+		result.Inputs = append(result.Inputs, is)
+	}
+
+	result.TotalFamilies = len(families)
+
+	for _, fs := range families {
+		result.Families = append(result.Families, *fs)
+	}
+
+	sort.Slice(result.Families, func(a, b int) bool {
+		if x, y := result.Families[a].Series, result.Families[b].Series; x != y {
+			return x > y
+		}
+
+		return result.Families[a].Name < result.Families[b].Name
+	})
+
+	for name, ls := range labels {
+		ls.Values = len(labelValues[name])
+		ls.Families = len(labelFamilies[name])
+
+		result.Labels = append(result.Labels, *ls)
+	}
+
+	sort.Slice(result.Labels, func(a, b int) bool {
+		if x, y := result.Labels[a].Values, result.Labels[b].Values; x != y {
+			return x > y
+		}
+
+		return result.Labels[a].Name < result.Labels[b].Name
+	})
+
+	if top > 0 {
+		result.Families = result.Families[:min(top, len(result.Families))]
+		result.Labels = result.Labels[:min(top, len(result.Labels))]
+	}
+
+	return result
+}
+
+// writeTable writes rows with aligned columns.
+func writeTable(w io.Writer, header string, rows []string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintln(tw, header)
+
+	for _, i := range rows {
+		fmt.Fprintln(tw, i)
+	}
+
+	return tw.Flush()
+}
+
+func writeStatsText(w io.Writer, stats cardinalityStats) error {
+	var inputs, families, labels []string
+
+	for _, i := range stats.Inputs {
+		inputs = append(inputs, fmt.Sprintf("%s\t%d\t%d\t%d", i.Name, i.Families, i.Series, i.Samples))
+	}
+
+	inputs = append(inputs, fmt.Sprintf("(total)\t%d\t%d\t%d", stats.TotalFamilies, stats.Series, stats.Samples))
+
+	for _, i := range stats.Families {
+		families = append(families, fmt.Sprintf("%s\t%s\t%d\t%d", i.Name, i.Type, i.Series, i.Samples))
+	}
+
+	for _, i := range stats.Labels {
+		labels = append(labels, fmt.Sprintf("%s\t%d\t%d\t%d", i.Name, i.Values, i.Series, i.Families))
+	}
+
+	for idx, i := range []struct {
+		header string
+		rows   []string
+	}{
+		{"INPUT\tFAMILIES\tSERIES\tSAMPLES", inputs},
+		{"FAMILY\tTYPE\tSERIES\tSAMPLES", families},
+		{"LABEL\tVALUES\tSERIES\tFAMILIES", labels},
+	} {
+		if idx > 0 {
+			io.WriteString(w, "\n")
+		}
+
+		if err := writeTable(w, i.header, i.rows); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeStatsJSON(w io.Writer, stats cardinalityStats) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(stats)
+}
+
+type statsCommand struct {
+	format string
+	top    int
+}
+
+var _ subcommand = (*statsCommand)(nil)
+
+func (c *statsCommand) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.format, "format", "text", `Output format ("text" or "json")`)
+	fs.IntVar(&c.top, "top", 10, "Number of families and labels to show (zero for all)")
+}
+
+func (c *statsCommand) run(ctx context.Context, fs *flag.FlagSet) error {
+	var write func(io.Writer, cardinalityStats) error
+
+	switch c.format {
+	case "text":
+		write = writeStatsText
+	case "json":
+		write = writeStatsJSON
+	default:
+		return &usageError{fmt.Errorf("unknown format %q", c.format)}
+	}
+
+	if c.top < 0 {
+		return &usageError{fmt.Errorf("invalid number of entries %d", c.top)}
+	}
+
+	inputPaths := fs.Args()
+
+	if len(inputPaths) == 0 {
+		inputPaths = []string{stdinPlaceholder}
+	}
+
+	var parsed []parsedInput
+
+	for _, w := range inputWrappersFromArgs(inputPaths, inputOptions{}) {
+		p, err := readMetricFamilies(ctx, w, nil)
+		if err != nil {
+			return err
+		}
+
+		parsed = append(parsed, p)
+	}
+
+	return write(stdoutWriter, computeStats(parsed, c.top))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestComputeStats(t *testing.T) {
+	first := mustParseInput(t, "first", `# TYPE up gauge
+up{job="a"} 1
+up{job="b",instance="x"} 1
+# TYPE latency_seconds histogram
+latency_seconds_bucket{le="1"} 1
+latency_seconds_bucket{le="+Inf"} 2
+latency_seconds_sum 1
+latency_seconds_count 2
+`)
+	second := mustParseInput(t, "second", `# TYPE up gauge
+up{job="c"} 0
+`)
+
+	for _, tc := range []struct {
+		name   string
+		inputs []parsedInput
+		top    int
+		want   cardinalityStats
+	}{
+		{
+			name: "empty",
+			want: cardinalityStats{
+				Inputs:   []inputStats{},
+				Families: []familyStats{},
+				Labels:   []labelStats{},
+			},
+		},
+		{
+			name:   "all",
+			inputs: []parsedInput{first, second},
+			want: cardinalityStats{
+				Series:        4,
+				Samples:       7,
+				TotalFamilies: 2,
+				Inputs: []inputStats{
+					{Name: "first", Families: 2, Series: 3, Samples: 6},
+					{Name: "second", Families: 1, Series: 1, Samples: 1},
+				},
+				Families: []familyStats{
+					{Name: "up", Type: "gauge", Series: 3, Samples: 3},
+					{Name: "latency_seconds", Type: "histogram", Series: 1, Samples: 4},
+				},
+				Labels: []labelStats{
+					{Name: "job", Values: 3, Series: 3, Families: 1},
+					{Name: "instance", Values: 1, Series: 1, Families: 1},
+				},
+			},
+		},
+		{
+			name:   "top",
+			inputs: []parsedInput{first},
+			top:    1,
+			want: cardinalityStats{
+				Series:        3,
+				Samples:       6,
+				TotalFamilies: 2,
+				Inputs: []inputStats{
+					{Name: "first", Families: 2, Series: 3, Samples: 6},
+				},
+				Families: []familyStats{
+					{Name: "up", Type: "gauge", Series: 2, Samples: 2},
+				},
+				Labels: []labelStats{
+					{Name: "job", Values: 2, Series: 2, Families: 1},
+				},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := computeStats(tc.inputs, tc.top)
+
+			if diff := cmp.Diff(got, tc.want); diff != "" {
+				t.Errorf("computeStats() difference (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestWriteStatsText(t *testing.T) {
+	var buf strings.Builder
+
+	if err := writeStatsText(&buf, cardinalityStats{
+		Series:        3,
+		Samples:       6,
+		TotalFamilies: 2,
+		Inputs: []inputStats{
+			{Name: "first", Families: 2, Series: 3, Samples: 6},
+		},
+		Families: []familyStats{
+			{Name: "up", Type: "gauge", Series: 2, Samples: 2},
+		},
+		Labels: []labelStats{
+			{Name: "job", Values: 2, Series: 2, Families: 1},
+		},
+	}); err != nil {
+		t.Errorf("writeStatsText() failed: %v", err)
+	}
+
+	want := `INPUT    FAMILIES  SERIES  SAMPLES
+first    2         3       6
+(total)  2         3       6
+
+FAMILY  TYPE   SERIES  SAMPLES
+up      gauge  2       2
+
+LABEL  VALUES  SERIES  FAMILIES
+job    2       2       1
+`
+
+	if diff := cmp.Diff(buf.String(), want); diff != "" {
+		t.Errorf("writeStatsText() difference (-got +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchInputs invokes fn once immediately and then again whenever a path
+// accepted by the match function changes within one of the watched
+// directories. Changes are collected until no further change occurs for the
+// debounce duration. Failures of fn are logged. The function returns when the
+// context is cancelled.
+//
+// Directories are watched instead of files as files replaced by renaming
+// would otherwise no longer be watched.
+func watchInputs(ctx context.Context, dirs []string, match func(string) bool, debounce time.Duration, fn func(context.Context) error) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	defer watcher.Close()
+
+	for _, i := range dirs {
+		if err := watcher.Add(i); err != nil {
+			return err
+		}
+	}
+
+	regenerate := func() {
+		if err := fn(ctx); isPartialFailure(err) {
+			log.Printf("Merging partially failed: %v", err)
+		} else if err != nil {
+			log.Printf("Merging failed: %v", err)
+		}
+	}
+
+	regenerate()
+
+	timer := time.NewTimer(debounce)
+	timer.Stop()
+
+	var pending <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case ev, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+
+			// Permission changes don't modify content
+			if ev.Op == fsnotify.Chmod || !match(ev.Name) {
+				continue
+			}
+
+			timer.Reset(debounce)
+			pending = timer.C
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+
+			log.Printf("Watching inputs: %v", err)
+
+		case <-pending:
+			pending = nil
+			regenerate()
+		}
+	}
+}
+
+// watchSpec collects the directories to watch and the paths within them
+// relevant for merging.
+type watchSpec struct {
+	dirs     []string
+	seenDirs map[string]struct{}
+
+	// Individual files
+	files map[string]struct{}
+
+	// Glob patterns for entries by directory
+	patterns map[string][]string
+
+	// Paths never considered relevant, e.g. outputs
+	excluded map[string]struct{}
+}
+
+func newWatchSpec() *watchSpec {
+	return &watchSpec{
+		seenDirs: map[string]struct{}{},
+		files:    map[string]struct{}{},
+		patterns: map[string][]string{},
+		excluded: map[string]struct{}{},
+	}
+}
+
+func (s *watchSpec) addDir(dir string) {
+	if _, ok := s.seenDirs[dir]; !ok {
+		s.seenDirs[dir] = struct{}{}
+		s.dirs = append(s.dirs, dir)
+	}
+}
+
+func (s *watchSpec) addFile(path string) {
+	path = filepath.Clean(path)
+
+	s.files[path] = struct{}{}
+	s.addDir(filepath.Dir(path))
+}
+
+func (s *watchSpec) addPattern(dir, pattern string) {
+	dir = filepath.Clean(dir)
+
+	s.patterns[dir] = append(s.patterns[dir], pattern)
+	s.addDir(dir)
+}
+
+func (s *watchSpec) exclude(path string) {
+	s.excluded[filepath.Clean(path)] = struct{}{}
+}
+
+// match reports whether a changed path is relevant.
+func (s *watchSpec) match(path string) bool {
+	path = filepath.Clean(path)
+
+	if _, ok := s.excluded[path]; ok {
+		return false
+	}
+
+	if _, ok := s.files[path]; ok {
+		return true
+	}
+
+	for _, pattern := range s.patterns[filepath.Dir(path)] {
+		if matched, err := filepath.Match(pattern, filepath.Base(path)); err == nil && matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// watchTargets returns the directories to watch for changes to the inputs of
+// all jobs and a function reporting whether a changed path is relevant.
+// Outputs are never relevant.
+func watchTargets(jobs []*mergeJob) ([]string, func(string) bool) {
+	spec := newWatchSpec()
+
+	for _, j := range jobs {
+		for _, s := range j.sources {
+			s.watch(spec)
+		}
+
+		for _, i := range j.outputPaths() {
+			spec.exclude(i)
+		}
+	}
+
+	return spec.dirs, spec.match
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWatchTargets(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		flags     cliFlags
+		args      []string
+		wantDirs  []string
+		match     []string
+		dontMatch []string
+	}{
+		{
+			name:      "files",
+			args:      []string{"a/first.prom", "a/second.prom", "b/./third.prom"},
+			wantDirs:  []string{"a", "b"},
+			match:     []string{"a/first.prom", "b/third.prom"},
+			dontMatch: []string{"a/third.prom", "a/.first.prom.tmp", "c/first.prom"},
+		},
+		{
+			name:      "commands and sockets",
+			args:      []string{"a/first.prom", "exec:b/collect --flag", "unix:/run/app.sock"},
+			wantDirs:  []string{"a"},
+			match:     []string{"a/first.prom"},
+			dontMatch: []string{"b/collect", "/run/app.sock"},
+		},
+		{
+			name: "dirs",
+			flags: cliFlags{
+				dirs:            true,
+				dirEntryPattern: "[^.]*.prom",
+				outputFile:      "a/all.prom",
+			},
+			args:      []string{"a", "b"},
+			wantDirs:  []string{"a", "b"},
+			match:     []string{"a/first.prom", "b/all.prom"},
+			dontMatch: []string{"a/all.prom", "a/.first.prom", "b/first.txt"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dirs, match := watchTargets([]*mergeJob{tc.flags.job(tc.args)})
+
+			if diff := cmp.Diff(dirs, tc.wantDirs); diff != "" {
+				t.Errorf("watchTargets() dirs difference (-got +want):\n%s", diff)
+			}
+
+			for _, i := range tc.match {
+				if !match(i) {
+					t.Errorf("match(%q) returned false", i)
+				}
+			}
+
+			for _, i := range tc.dontMatch {
+				if match(i) {
+					t.Errorf("match(%q) returned true", i)
+				}
+			}
+		})
+	}
+}
+
+func TestWatchInputs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	tmpdir := t.TempDir()
+	path := filepath.Join(tmpdir, "input.prom")
+
+	calls := make(chan struct{}, 10)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- watchInputs(ctx, []string{tmpdir}, func(p string) bool {
+			return p == path
+		}, 10*time.Millisecond, func(context.Context) error {
+			calls <- struct{}{}
+			return nil
+		})
+	}()
+
+	waitForCall := func() {
+		t.Helper()
+
+		select {
+		case <-calls:
+		case <-time.After(10 * time.Second):
+			t.Fatal("Timeout while waiting for invocation")
+		}
+	}
+
+	// Initial invocation
+	waitForCall()
+
+	if err := os.WriteFile(filepath.Join(tmpdir, "unrelated.txt"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(path, []byte("metric 1\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	waitForCall()
+
+	cancel()
+
+	if err := <-done; err != nil {
+		t.Errorf("watchInputs() failed: %v", err)
+	}
+
+	select {
+	case <-calls:
+		t.Errorf("Unexpected additional invocation")
+	default:
+	}
+}